@@ -26,28 +26,42 @@ import (
 	"fmt"
 	"github.com/stretchr/testify/assert"
 	"io"
+	"io/ioutil"
 	"log"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"os/exec"
+	"path/filepath"
+	"strings"
 	"syscall"
 	"testing"
+	"time"
 
 	hv1 "google.golang.org/grpc/health/grpc_health_v1"
 )
 
 var (
 	port        int
+	httpPort    int
 	caFile      string
 	caPath      string
+	sanCert     string
 	key         string
 	bin         string
 	stubSrvAddr string
+	httpAddr    string
 )
 
+// binTimeout limits run time of long-running gprobe commands
+const binTimeout = 10 * time.Second
+
 func init() {
 	flag.IntVar(&port, "stub-port", 54321, "port for the stub server")
+	flag.IntVar(&httpPort, "http-port", 54322, "port for gprobe HTTP server")
 	flag.StringVar(&caFile, "stub-cafile", "x509/certificate.pem", "path to the x509 certificate file")
 	flag.StringVar(&caPath, "stub-capath", "x509/", "path to the x509 certificates dir")
+	flag.StringVar(&sanCert, "stub-san-cert", "san-certificate.pem", "path to the x509 certificate with SANs not matching localhost")
 	flag.StringVar(&key, "stub-key", "key.pem", "path to the stub server private key")
 	flag.StringVar(&bin, "gprobe", "../gprobe", "path to the gprobe binary")
 }
@@ -55,6 +69,7 @@ func init() {
 func TestMain(m *testing.M) {
 	flag.Parse()
 	stubSrvAddr = fmt.Sprintf("%s:%d", "localhost", port)
+	httpAddr = fmt.Sprintf("%s:%d", "localhost", httpPort)
 	os.Exit(m.Run())
 }
 
@@ -81,7 +96,7 @@ func TestShouldFailIfServerIsNotListening(t *testing.T) {
 	stdout, stderr, exitcode := runBin(t, stubSrvAddr)
 
 	// then
-	assert.Equal(t, 127, exitcode)
+	assert.Equal(t, 5, exitcode)
 	assert.Empty(t, stdout)
 	assert.Contains(t, stderr, "application isn't listening")
 }
@@ -98,7 +113,7 @@ func TestShouldFailIfServerDoesNotImplementHealthCheckProtocol(t *testing.T) {
 	stdout, stderr, exitcode := runBin(t, stubSrvAddr)
 
 	// then
-	assert.Equal(t, 127, exitcode)
+	assert.Equal(t, 8, exitcode)
 	assert.Empty(t, stdout)
 	assert.Equal(t, stderr, "rpc error: server doesn't implement gRPC health-checking protocol\n")
 }
@@ -169,31 +184,56 @@ func TestShouldFailIfServiceHealthCheckIsNotRegistered(t *testing.T) {
 	stdout, stderr, exitcode := runBin(t, stubSrvAddr, "my.service.Foo")
 
 	// then
-	assert.Equal(t, 127, exitcode)
+	assert.Equal(t, 9, exitcode)
 	assert.Empty(t, stdout)
 	assert.Equal(t, stderr, "rpc error: unknown service my.service.Foo\n")
 }
 
-// TLS tests
+func TestShouldFailIfServerAddressCanNotBeResolved(t *testing.T) {
+	// when
+	stdout, stderr, exitcode := runBin(t, "nosuchhost.invalid:1234")
 
-func TestShouldFailOnTlsVerificationWithSelfSignedCert(t *testing.T) {
+	// then
+	assert.Equal(t, 4, exitcode)
+	assert.Empty(t, stdout)
+	assert.Regexp(t, "^can't resolve server address: lookup nosuchhost.invalid", stderr)
+}
+
+func TestShouldFailIfServerDoesNotRespondInTime(t *testing.T) {
 	// given
-	srv, _, err := StartServer(port, caFile, key)
+	srv, _, err := StartInsecureServer(port, Delay(5*time.Second))
 	if err != nil {
 		log.Fatalf("can't start stub server: %v", err)
 	}
-	defer srv.GracefulStop()
+	defer srv.Stop()
 
 	// when
-	stdout, stderr, exitcode := runBin(t, "--tls", stubSrvAddr)
+	stdout, stderr, exitcode := runBin(t, "--timeout", "200ms", stubSrvAddr)
 
 	// then
-	assert.Equal(t, 127, exitcode)
+	assert.Equal(t, 7, exitcode)
 	assert.Empty(t, stdout)
-	assert.Contains(t, stderr, "TLS handshake failed")
+	assert.Contains(t, stderr, "deadline exceeded")
 }
 
-func TestShouldBeAbleToSkipTlsVerification(t *testing.T) {
+func TestShouldFailIfServerRespondsSlowerThanMaxLatency(t *testing.T) {
+	// given
+	srv, _, err := StartInsecureServer(port, Delay(300*time.Millisecond))
+	if err != nil {
+		log.Fatalf("can't start stub server: %v", err)
+	}
+	defer srv.GracefulStop()
+
+	// when
+	stdout, stderr, exitcode := runBin(t, "--max-latency", "100ms", stubSrvAddr)
+
+	// then
+	assert.Equal(t, 12, exitcode)
+	assert.Equal(t, "SERVING\n", stdout)
+	assert.Regexp(t, `^response time [0-9.]+ms exceeds --max-latency 100ms\n$`, stderr)
+}
+
+func TestShouldPrintTimingBreakdownInVerboseMode(t *testing.T) {
 	// given
 	srv, _, err := StartServer(port, caFile, key)
 	if err != nil {
@@ -202,63 +242,1799 @@ func TestShouldBeAbleToSkipTlsVerification(t *testing.T) {
 	defer srv.GracefulStop()
 
 	// when
-	stdout, stderr, exitcode := runBin(t, "--tls-insecure", stubSrvAddr)
+	stdout, stderr, exitcode := runBin(t, "--tls-insecure", "--max-latency", "5s", "--verbose", stubSrvAddr)
 
 	// then
 	assert.Equal(t, 0, exitcode)
 	assert.Equal(t, "SERVING\n", stdout)
+	assert.Regexp(t, `localhost:\d+: timing: resolve [0-9.]+[µnm]?s, connect [0-9.]+[µnm]?s, tls [0-9.]+[µnm]?s, `+
+		`rpc [0-9.]+[µnm]?s, total [0-9.]+[µnm]?s\n`, stderr)
+	assert.NotRegexp(t, `tls 0s`, stderr)
+}
+
+func TestShouldExitWith127OnAnyFailureWithLegacyExitCodes(t *testing.T) {
+	// given
+	srv, _, err := StartInsecureServer(port)
+	if err != nil {
+		log.Fatalf("can't start stub server: %v", err)
+	}
+	defer srv.GracefulStop()
+
+	// when
+	_, _, unknownServiceExitcode := runBin(t, "--legacy-exit-codes", stubSrvAddr, "my.service.Foo")
+	_, _, unresolvedExitcode := runBin(t, "--legacy-exit-codes", "nosuchhost.invalid:1234")
+	_, _, healthExitcode := runBin(t, "--legacy-exit-codes", stubSrvAddr)
+
+	// then
+	assert.Equal(t, 127, unknownServiceExitcode)
+	assert.Equal(t, 127, unresolvedExitcode)
+	assert.Equal(t, 0, healthExitcode)
+}
+
+func TestShouldWarnIfStatusIsMappedToWarning(t *testing.T) {
+	// given
+	srv, svc, err := StartInsecureServer(port)
+	if err != nil {
+		log.Fatalf("can't start stub server: %v", err)
+	}
+	defer srv.GracefulStop()
+	svc.SetServingStatus("foo", hv1.HealthCheckResponse_NOT_SERVING)
+
+	// when
+	stdout, stderr, exitcode := runBin(t, "--map", "NOT_SERVING=warn", stubSrvAddr, "foo")
+
+	// then
+	assert.Equal(t, 11, exitcode)
+	assert.Equal(t, "NOT_SERVING\n", stdout)
+	assert.Equal(t, "health-check warning: status is NOT_SERVING\n", stderr)
+}
+
+func TestShouldPassIfStatusIsExpected(t *testing.T) {
+	// given
+	srv, svc, err := StartInsecureServer(port)
+	if err != nil {
+		log.Fatalf("can't start stub server: %v", err)
+	}
+	defer srv.GracefulStop()
+	svc.SetServingStatus("foo", hv1.HealthCheckResponse_NOT_SERVING)
+
+	// when
+	stdout, stderr, exitcode := runBin(t, "--expect", "NOT_SERVING", stubSrvAddr, "foo")
+	_, _, servingExitcode := runBin(t, "--expect", "NOT_SERVING", stubSrvAddr)
+
+	// then
+	assert.Equal(t, 0, exitcode)
+	assert.Equal(t, "NOT_SERVING\n", stdout)
 	assert.Empty(t, stderr)
+	assert.Equal(t, 2, servingExitcode)
 }
 
-func TestShouldBeAbleToSetCustomCAFile(t *testing.T) {
+func TestShouldReportUnknownServiceAsServiceUnknownStatus(t *testing.T) {
 	// given
-	srv, _, err := StartServer(port, caFile, key)
+	srv, _, err := StartInsecureServer(port)
 	if err != nil {
 		log.Fatalf("can't start stub server: %v", err)
 	}
 	defer srv.GracefulStop()
 
 	// when
-	stdout, stderr, exitcode := runBin(t, "--tls-cafile", caFile, stubSrvAddr)
+	stdout, stderr, exitcode := runBin(t, "--not-found-as-unknown", "--map", "SERVICE_UNKNOWN=warn", stubSrvAddr,
+		"my.service.Foo")
+
+	// then
+	assert.Equal(t, 11, exitcode)
+	assert.Equal(t, "SERVICE_UNKNOWN\n", stdout)
+	assert.Equal(t, "health-check warning: status is SERVICE_UNKNOWN\n", stderr)
+}
+
+func TestShouldFailIfStatusMapIsInvalid(t *testing.T) {
+	// when
+	stdout, stderr, exitcode := runBin(t, "--map", "DRAINING=warn", stubSrvAddr)
+
+	// then
+	assert.Equal(t, 1, exitcode)
+	assert.Contains(t, stdout, "USAGE")
+	assert.Contains(t, stderr, "unknown health status DRAINING")
+}
+
+// nagios tests
+
+func TestShouldReportNagiosOK(t *testing.T) {
+	// given
+	srv, _, err := StartInsecureServer(port)
+	if err != nil {
+		log.Fatalf("can't start stub server: %v", err)
+	}
+	defer srv.GracefulStop()
+
+	// when
+	stdout, stderr, exitcode := runBin(t, "--format", "nagios", "--warning", "1s", "--critical", "2s", "--timeout", "3s",
+		stubSrvAddr)
 
 	// then
 	assert.Equal(t, 0, exitcode)
-	assert.Equal(t, "SERVING\n", stdout)
+	assert.Regexp(t, `^OK - localhost:\d+ is SERVING \| time=[0-9.]+s;1;2;0;3\n$`, stdout)
 	assert.Empty(t, stderr)
 }
 
-func TestShouldBeAbleToSetCustomCAPath(t *testing.T) {
+func TestShouldReportNagiosCriticalIfServiceIsNotServing(t *testing.T) {
 	// given
-	srv, _, err := StartServer(port, caFile, key)
+	srv, svc, err := StartInsecureServer(port)
 	if err != nil {
 		log.Fatalf("can't start stub server: %v", err)
 	}
 	defer srv.GracefulStop()
+	svc.SetServingStatus("foo", hv1.HealthCheckResponse_NOT_SERVING)
 
 	// when
-	stdout, stderr, exitcode := runBin(t, "--tls-capath", caPath, stubSrvAddr)
+	stdout, stderr, exitcode := runBin(t, "--format", "nagios", stubSrvAddr, "foo")
+	warnStdout, _, warnExitcode := runBin(t, "--format", "nagios", "--map", "NOT_SERVING=warn", stubSrvAddr, "foo")
+
+	// then
+	assert.Equal(t, 2, exitcode)
+	assert.Regexp(t, `^CRITICAL - localhost:\d+ foo is NOT_SERVING \| time=`, stdout)
+	assert.Empty(t, stderr)
+	assert.Equal(t, 1, warnExitcode)
+	assert.Regexp(t, `^WARNING - localhost:\d+ foo is NOT_SERVING \| time=`, warnStdout)
+}
+
+func TestShouldReportNagiosCriticalIfServerIsNotListening(t *testing.T) {
+	// when
+	stdout, stderr, exitcode := runBin(t, "--format", "nagios", stubSrvAddr)
+
+	// then
+	assert.Equal(t, 2, exitcode)
+	assert.Regexp(t, `^CRITICAL - localhost:\d+: connection refused: .+ \| time=`, stdout)
+	assert.Empty(t, stderr)
+}
+
+func TestShouldReportNagiosWarningIfResponseIsSlow(t *testing.T) {
+	// given
+	srv, _, err := StartInsecureServer(port, Delay(200*time.Millisecond))
+	if err != nil {
+		log.Fatalf("can't start stub server: %v", err)
+	}
+	defer srv.GracefulStop()
+
+	// when
+	stdout, _, exitcode := runBin(t, "--format", "nagios", "--warning", "100ms", "--critical", "5s", stubSrvAddr)
+
+	// then
+	assert.Equal(t, 1, exitcode)
+	assert.Regexp(t, `^WARNING - localhost:\d+ is SERVING, response time [0-9.]+ms exceeds 100ms \| time=`, stdout)
+}
+
+func TestShouldReportNagiosUnknownOnUsageError(t *testing.T) {
+	// when
+	stdout, stderr, exitcode := runBin(t, "--format", "nagios")
+
+	// then
+	assert.Equal(t, 3, exitcode)
+	assert.Equal(t, "UNKNOWN - exactly 1 to 2 arguments are required\n", stdout)
+	assert.Empty(t, stderr)
+}
+
+func TestShouldPrintJSONResult(t *testing.T) {
+	// given
+	srv, svc, err := StartInsecureServer(port)
+	if err != nil {
+		log.Fatalf("can't start stub server: %v", err)
+	}
+	defer srv.GracefulStop()
+	svc.SetServingStatus("foo", hv1.HealthCheckResponse_NOT_SERVING)
+
+	// when
+	stdout, stderr, exitcode := runBin(t, "--output", "json", stubSrvAddr, "foo")
+
+	// then
+	assert.Equal(t, 2, exitcode)
+	assert.Regexp(t, `^\{"target":"localhost:\d+","service":"foo","status":"NOT_SERVING","code":"OK",`+
+		`"latency_seconds":[0-9.e-]+,"dial_seconds":[0-9.e-]+,"rpc_seconds":[0-9.e-]+,"attempts":1,"tls":"none","exit_code":2\}\n$`, stdout)
+	assert.Contains(t, stderr, "health-check failed")
+}
+
+func TestShouldPrintJSONResultWithRPCError(t *testing.T) {
+	// given
+	srv, _, err := StartInsecureServer(port)
+	if err != nil {
+		log.Fatalf("can't start stub server: %v", err)
+	}
+	defer srv.GracefulStop()
+
+	// when
+	stdout, _, exitcode := runBin(t, "-o", "json", stubSrvAddr, "my.service.Foo")
+
+	// then
+	assert.Equal(t, 9, exitcode)
+	assert.Contains(t, stdout, `"code":"NotFound","message":"unknown service"`)
+	assert.Contains(t, stdout, `"exit_code":9`)
+	assert.NotContains(t, stdout, `"status"`)
+}
+
+// retry tests
+
+func TestShouldRetryUntilServerStartsListening(t *testing.T) {
+	// given no server
+
+	// when
+	_, wait := startBin(t, "--timeout", "5s", "--retries", "20", "--retry-backoff", "50ms", "--retry-max-backoff", "200ms",
+		"--verbose", stubSrvAddr)
+	time.Sleep(500 * time.Millisecond)
+	srv, _, err := StartInsecureServer(port)
+	if err != nil {
+		log.Fatalf("can't start stub server: %v", err)
+	}
+	defer srv.GracefulStop()
+	stdout, stderr, exitcode := wait()
+
+	// then
+	assert.Equal(t, 0, exitcode)
+	assert.Equal(t, "SERVING\n", stdout)
+	assert.Contains(t, stderr, "attempt 1 of 21: connection refused")
+	assert.Regexp(t, "attempt \\d+ of 21: SERVING\n.+: timing: .+\n$", stderr)
+}
+
+func TestShouldGiveUpAfterConfiguredNumberOfRetries(t *testing.T) {
+	// given no server
+
+	// when
+	stdout, stderr, exitcode := runBin(t, "--retries", "2", "--retry-backoff", "10ms", "--verbose", stubSrvAddr)
+
+	// then
+	assert.Equal(t, 5, exitcode)
+	assert.Empty(t, stdout)
+	assert.Contains(t, stderr, "attempt 1 of 3: connection refused")
+	assert.Contains(t, stderr, "attempt 3 of 3: connection refused")
+}
+
+func TestShouldNotRetryPermanentErrors(t *testing.T) {
+	// given
+	srv, _, err := StartInsecureServer(port)
+	if err != nil {
+		log.Fatalf("can't start stub server: %v", err)
+	}
+	defer srv.GracefulStop()
+
+	// when
+	_, stderr, exitcode := runBin(t, "--retries", "2", "--verbose", stubSrvAddr, "my.service.Foo")
+
+	// then
+	assert.Equal(t, 9, exitcode)
+	assert.Contains(t, stderr, "attempt 1 of 3: rpc error: unknown service my.service.Foo")
+	assert.NotContains(t, stderr, "attempt 2 of 3")
+}
+
+func TestShouldNotShortenFirstAttemptWhenRetriesAreEnabled(t *testing.T) {
+	// given
+	srv, _, err := StartInsecureServer(port, Delay(500*time.Millisecond))
+	if err != nil {
+		log.Fatalf("can't start stub server: %v", err)
+	}
+	defer srv.GracefulStop()
+
+	// when
+	stdout, stderr, exitcode := runBin(t, "--retries", "3", "--timeout", "1s", "--verbose", stubSrvAddr)
 
 	// then
 	assert.Equal(t, 0, exitcode)
 	assert.Equal(t, "SERVING\n", stdout)
+	assert.Contains(t, stderr, "attempt 1 of 4: SERVING")
+}
+
+// all services tests
+
+func TestShouldCheckAllServicesListedByReflection(t *testing.T) {
+	// given
+	srv, svc, err := StartReflectionServer(port, "my.service.Foo", "my.service.Bar")
+	if err != nil {
+		log.Fatalf("can't start stub server: %v", err)
+	}
+	defer srv.GracefulStop()
+	svc.SetServingStatus("my.service.Foo", hv1.HealthCheckResponse_SERVING)
+	svc.SetServingStatus("my.service.Bar", hv1.HealthCheckResponse_SERVING)
+
+	// when
+	stdout, stderr, exitcode := runBin(t, "--all-services", stubSrvAddr)
+
+	// then
+	assert.Equal(t, 0, exitcode)
+	assert.Equal(t, "SERVICE         STATUS\nmy.service.Bar  SERVING\nmy.service.Foo  SERVING\n", stdout)
 	assert.Empty(t, stderr)
 }
 
-func runBin(t *testing.T, args ...string) (stdout string, stderr string, exitcode int) {
-	gprobe := exec.Command(bin, args...)
-	stdoutPipe, _ := gprobe.StdoutPipe()
-	stderrPipe, _ := gprobe.StderrPipe()
+func TestShouldCheckAllServicesListedByLegacyReflection(t *testing.T) {
+	// given
+	srv, svc, err := StartLegacyReflectionServer(port, "my.service.Foo", "my.service.Bar")
+	if err != nil {
+		log.Fatalf("can't start stub server: %v", err)
+	}
+	defer srv.GracefulStop()
+	svc.SetServingStatus("my.service.Foo", hv1.HealthCheckResponse_SERVING)
+	svc.SetServingStatus("my.service.Bar", hv1.HealthCheckResponse_SERVING)
 
-	err := gprobe.Start()
+	// when
+	stdout, stderr, exitcode := runBin(t, "--all-services", stubSrvAddr)
+
+	// then
+	assert.Equal(t, 0, exitcode)
+	assert.Equal(t, "SERVICE         STATUS\nmy.service.Bar  SERVING\nmy.service.Foo  SERVING\n", stdout)
+	assert.Empty(t, stderr)
+}
+
+func TestShouldFailIfAnyServiceListedByReflectionIsNotServing(t *testing.T) {
+	// given
+	srv, svc, err := StartReflectionServer(port, "my.service.Foo", "my.service.Bar", "my.service.Baz")
 	if err != nil {
-		t.Error(err)
+		log.Fatalf("can't start stub server: %v", err)
 	}
+	defer srv.GracefulStop()
+	svc.SetServingStatus("my.service.Foo", hv1.HealthCheckResponse_SERVING)
+	svc.SetServingStatus("my.service.Bar", hv1.HealthCheckResponse_NOT_SERVING)
 
-	stdout = readPipe(t, stdoutPipe)
-	stderr = readPipe(t, stderrPipe)
-	exitcode = waitForExitCode(t, gprobe)
+	// when
+	stdout, stderr, exitcode := runBin(t, "--all-services", stubSrvAddr)
 
-	return
+	// then
+	assert.Equal(t, 2, exitcode)
+	assert.Equal(t, "SERVICE         STATUS\n"+
+		"my.service.Bar  NOT_SERVING\n"+
+		"my.service.Baz  rpc error: unknown service my.service.Baz\n"+
+		"my.service.Foo  SERVING\n", stdout)
+	assert.Equal(t, "health-check failed for 2 of 3 services\n", stderr)
+}
+
+func TestShouldApplyStatusMappingToAllServicesListedByReflection(t *testing.T) {
+	// given
+	srv, svc, err := StartReflectionServer(port, "my.service.Foo", "my.service.Bar")
+	if err != nil {
+		log.Fatalf("can't start stub server: %v", err)
+	}
+	defer srv.GracefulStop()
+	svc.SetServingStatus("my.service.Foo", hv1.HealthCheckResponse_SERVING)
+
+	// when
+	stdout, stderr, exitcode := runBin(t, "--all-services", "--not-found-as-unknown", "--map", "SERVICE_UNKNOWN=warn",
+		stubSrvAddr)
+
+	// then
+	assert.Equal(t, 11, exitcode)
+	assert.Equal(t, "SERVICE         STATUS\n"+
+		"my.service.Bar  SERVICE_UNKNOWN\n"+
+		"my.service.Foo  SERVING\n", stdout)
+	assert.Equal(t, "health-check warning for 1 of 2 services\n", stderr)
+}
+
+func TestShouldFailToCheckAllServicesIfReflectionIsNotSupported(t *testing.T) {
+	// given
+	srv, _, err := StartInsecureServer(port)
+	if err != nil {
+		log.Fatalf("can't start stub server: %v", err)
+	}
+	defer srv.GracefulStop()
+
+	// when
+	stdout, stderr, exitcode := runBin(t, "--all-services", stubSrvAddr)
+
+	// then
+	assert.Equal(t, 8, exitcode)
+	assert.Empty(t, stdout)
+	assert.Contains(t, stderr, "can't list services")
+}
+
+func TestShouldPrintJSONErrorIfServicesCanNotBeListed(t *testing.T) {
+	// given
+	srv, _, err := StartInsecureServer(port)
+	if err != nil {
+		log.Fatalf("can't start stub server: %v", err)
+	}
+	defer srv.GracefulStop()
+
+	// when
+	stdout, stderr, exitcode := runBin(t, "--all-services", "-o", "json", stubSrvAddr)
+
+	// then
+	assert.Equal(t, 8, exitcode)
+	assert.Regexp(t, `^\{"target":"localhost:[0-9]+","service":"","code":"Unimplemented",.*"exit_code":8\}\n$`, stdout)
+	assert.Contains(t, stderr, "can't list services")
+}
+
+// unix socket tests
+
+func TestShouldCheckServerListeningOnUnixSocket(t *testing.T) {
+	// given
+	dir, err := ioutil.TempDir("", "gprobe-acctest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	socket := filepath.Join(dir, "app.sock")
+	srv, svc, err := StartUnixServer(socket)
+	if err != nil {
+		log.Fatalf("can't start stub server: %v", err)
+	}
+	defer srv.GracefulStop()
+	svc.SetServingStatus("foo", hv1.HealthCheckResponse_SERVING)
+
+	// when
+	stdout, stderr, exitcode := runBin(t, "unix://"+socket, "foo")
+
+	// then
+	assert.Equal(t, 0, exitcode)
+	assert.Equal(t, "SERVING\n", stdout)
+	assert.Empty(t, stderr)
+}
+
+func TestShouldCheckServerListeningOnAbstractUnixSocket(t *testing.T) {
+	// given
+	srv, _, err := StartUnixServer("@gprobe-acctest")
+	if err != nil {
+		log.Fatalf("can't start stub server: %v", err)
+	}
+	defer srv.GracefulStop()
+
+	// when
+	stdout, stderr, exitcode := runBin(t, "unix-abstract:gprobe-acctest")
+
+	// then
+	assert.Equal(t, 0, exitcode)
+	assert.Equal(t, "SERVING\n", stdout)
+	assert.Empty(t, stderr)
+}
+
+func TestShouldSendAuthorityOverride(t *testing.T) {
+	// given
+	srv, _, err := StartUnixServer("@gprobe-acctest", RequireAuthority("app.example.com"))
+	if err != nil {
+		log.Fatalf("can't start stub server: %v", err)
+	}
+	defer srv.GracefulStop()
+
+	// when
+	_, _, exitcodeDefault := runBin(t, "unix-abstract:gprobe-acctest")
+	stdout, stderr, exitcode := runBin(t, "--authority", "app.example.com", "unix-abstract:gprobe-acctest")
+
+	// then
+	assert.Equal(t, 10, exitcodeDefault)
+	assert.Equal(t, 0, exitcode)
+	assert.Equal(t, "SERVING\n", stdout)
+	assert.Empty(t, stderr)
+}
+
+// metadata tests
+
+func TestShouldSendRequestMetadata(t *testing.T) {
+	// given
+	srv, _, err := StartInsecureServer(port, EchoMetadata())
+	if err != nil {
+		log.Fatalf("can't start stub server: %v", err)
+	}
+	defer srv.GracefulStop()
+
+	// when
+	stdout, stderr, exitcode := runBin(t, "-H", "X-Request-Id: 42", "-H", "x-tag:a", "-H", "x-tag:b",
+		"-H", "token-bin:AQID", "--print-headers", stubSrvAddr)
+
+	// then
+	assert.Equal(t, 0, exitcode)
+	assert.Equal(t, "SERVING\n", stdout)
+	assert.Contains(t, stderr, "header content-type: application/grpc\n")
+	assert.Contains(t, stderr, "trailer echo-token-bin: AQID\n"+
+		"trailer echo-x-request-id: 42\n"+
+		"trailer echo-x-tag: a\n"+
+		"trailer echo-x-tag: b\n")
+}
+
+func TestWatchShouldPrintResponseHeaders(t *testing.T) {
+	// given
+	srv, svc, err := StartInsecureServer(port)
+	if err != nil {
+		log.Fatalf("can't start stub server: %v", err)
+	}
+	defer srv.GracefulStop()
+	svc.SetServingStatus("foo", hv1.HealthCheckResponse_NOT_SERVING)
+
+	// when
+	_, stderr, exitcode := runBin(t, "watch", "--stop-on-failure", "--print-headers", stubSrvAddr, "foo")
+
+	// then
+	assert.Equal(t, 2, exitcode)
+	assert.Contains(t, stderr, "header content-type: application/grpc\n")
+}
+
+func TestWaitShouldPrintResponseHeaders(t *testing.T) {
+	// given
+	srv, svc, err := StartInsecureServer(port)
+	if err != nil {
+		log.Fatalf("can't start stub server: %v", err)
+	}
+	defer srv.GracefulStop()
+	svc.SetServingStatus("foo", hv1.HealthCheckResponse_SERVING)
+
+	// when
+	stdout, stderr, exitcode := runBin(t, "--wait-for", "SERVING", "--print-headers", stubSrvAddr, "foo")
+
+	// then
+	assert.Equal(t, 0, exitcode)
+	assert.Equal(t, "SERVING\n", stdout)
+	assert.Contains(t, stderr, "header content-type: application/grpc\n")
+}
+
+func TestShouldFailOnInvalidBinaryMetadata(t *testing.T) {
+	// when
+	_, stderr, exitcode := runBin(t, "-H", "token-bin:not base64", stubSrvAddr)
+
+	// then
+	assert.Equal(t, 1, exitcode)
+	assert.Contains(t, stderr, "value of binary header token-bin must be base64 encoded")
+}
+
+// multiple targets tests
+
+func TestShouldCheckAllTargetsFromFile(t *testing.T) {
+	// given
+	srv, svc, err := StartInsecureServer(port)
+	if err != nil {
+		log.Fatalf("can't start stub server: %v", err)
+	}
+	defer srv.GracefulStop()
+	svc.SetServingStatus("foo", hv1.HealthCheckResponse_SERVING)
+	svc.SetServingStatus("bar", hv1.HealthCheckResponse_NOT_SERVING)
+	targets := writeTempFile(t, fmt.Sprintf("%[1]s\n%[1]s foo\n%[1]s bar\n", stubSrvAddr))
+	defer os.Remove(targets)
+
+	// when
+	stdout, stderr, exitcode := runBin(t, "--targets", targets, "--parallelism", "2")
+
+	// then
+	assert.Equal(t, 2, exitcode)
+	assert.Equal(t, fmt.Sprintf("%[1]s SERVING\n%[1]s foo SERVING\n%[1]s bar NOT_SERVING\n", stubSrvAddr), stdout)
+	assert.Equal(t, "2 of 3 targets passed, 3 required\n", stderr)
+}
+
+func TestShouldPassIfEnoughTargetsPass(t *testing.T) {
+	// given
+	srv, svc, err := StartInsecureServer(port)
+	if err != nil {
+		log.Fatalf("can't start stub server: %v", err)
+	}
+	defer srv.GracefulStop()
+	svc.SetServingStatus("foo", hv1.HealthCheckResponse_SERVING)
+	targets := fmt.Sprintf("%[1]s foo\n%[1]s my.service.Foo\n", stubSrvAddr)
+
+	// when
+	stdout, stderr, exitcode := runBinWithStdin(t, targets, "--targets", "-", "--require", "1")
+
+	// then
+	assert.Equal(t, 0, exitcode)
+	assert.Equal(t, fmt.Sprintf("%[1]s foo SERVING\n%[1]s my.service.Foo rpc error: unknown service my.service.Foo\n",
+		stubSrvAddr), stdout)
+	assert.Empty(t, stderr)
+}
+
+// serve-http tests
+
+func TestServeHTTPShouldReportServiceHealth(t *testing.T) {
+	// given
+	srv, svc, err := StartInsecureServer(port)
+	if err != nil {
+		log.Fatalf("can't start stub server: %v", err)
+	}
+	defer srv.GracefulStop()
+	svc.SetServingStatus("foo", hv1.HealthCheckResponse_SERVING)
+	svc.SetServingStatus("bar", hv1.HealthCheckResponse_NOT_SERVING)
+
+	gprobe, wait := startBin(t, "serve-http", "--listen", httpAddr, stubSrvAddr)
+	defer wait()
+	defer gprobe.Process.Signal(os.Interrupt)
+
+	dataset := []struct {
+		path         string
+		statusCode   int
+		bodyContains string
+	}{
+		{"/healthz", 200, `"status":"SERVING"`},
+		{"/healthz/foo", 200, `"service":"foo","status":"SERVING"`},
+		{"/healthz/bar", 503, `"service":"bar","status":"NOT_SERVING"`},
+		{"/healthz/my.service.Foo", 503, `"code":"NotFound"`},
+		{"/metrics", 404, ""},
+	}
+
+	for _, tt := range dataset {
+		// when
+		statusCode, body := httpGet(t, "http://"+httpAddr+tt.path)
+
+		// then
+		assert.Equal(t, tt.statusCode, statusCode, tt.path)
+		assert.Contains(t, body, tt.bodyContains, tt.path)
+	}
+}
+
+func TestServeHTTPShouldExitOnInterrupt(t *testing.T) {
+	// given
+	srv, _, err := StartInsecureServer(port)
+	if err != nil {
+		log.Fatalf("can't start stub server: %v", err)
+	}
+	defer srv.GracefulStop()
+	gprobe, wait := startBin(t, "serve-http", "--listen", httpAddr, stubSrvAddr)
+	httpGet(t, "http://"+httpAddr+"/healthz")
+
+	// when
+	gprobe.Process.Signal(os.Interrupt)
+	stdout, _, exitcode := wait()
+
+	// then
+	assert.Equal(t, 0, exitcode)
+	assert.Empty(t, stdout)
+}
+
+// exporter tests
+
+func TestExporterShouldExportProbeMetrics(t *testing.T) {
+	// given
+	srv, svc, err := StartInsecureServer(port)
+	if err != nil {
+		log.Fatalf("can't start stub server: %v", err)
+	}
+	defer srv.GracefulStop()
+	svc.SetServingStatus("foo", hv1.HealthCheckResponse_SERVING)
+	svc.SetServingStatus("bar", hv1.HealthCheckResponse_NOT_SERVING)
+
+	gprobe, wait := startBin(t, "exporter", "--listen", httpAddr)
+	defer wait()
+	defer gprobe.Process.Signal(os.Interrupt)
+
+	dataset := []struct {
+		query      string
+		statusCode int
+		metrics    []string
+	}{
+		{"target=" + stubSrvAddr + "&service=foo", 200, []string{"\nprobe_success 1\n", "\ngrpc_health_status 1\n"}},
+		{"target=" + stubSrvAddr + "&service=bar", 200, []string{"\nprobe_success 0\n", "\ngrpc_health_status 2\n"}},
+		{"target=" + stubSrvAddr + "&service=baz", 200, []string{"\nprobe_success 0\n", "\nprobe_error_code 5\n"}},
+		{"target=" + stubSrvAddr + "&module=foo", 400, []string{"unknown module foo"}},
+		{"service=foo", 400, []string{"target parameter is missing"}},
+	}
+
+	for _, tt := range dataset {
+		// when
+		statusCode, body := httpGet(t, "http://"+httpAddr+"/probe?"+tt.query)
+
+		// then
+		assert.Equal(t, tt.statusCode, statusCode, tt.query)
+		for _, metric := range tt.metrics {
+			assert.Contains(t, body, metric, tt.query)
+		}
+	}
+}
+
+func TestExporterShouldUseModuleFromConfigFile(t *testing.T) {
+	// given
+	srv, _, err := StartServer(port, caFile, key)
+	if err != nil {
+		log.Fatalf("can't start stub server: %v", err)
+	}
+	defer srv.GracefulStop()
+	config := writeTempFile(t, "modules:\n  insecure:\n    tls-insecure: true\n")
+	defer os.Remove(config)
+
+	gprobe, wait := startBin(t, "exporter", "--listen", httpAddr, "--config", config)
+	defer wait()
+	defer gprobe.Process.Signal(os.Interrupt)
+
+	// when
+	_, plaintext := httpGet(t, "http://"+httpAddr+"/probe?target="+stubSrvAddr)
+	_, insecure := httpGet(t, "http://"+httpAddr+"/probe?target="+stubSrvAddr+"&module=insecure")
+
+	// then
+	assert.Contains(t, plaintext, "\nprobe_success 0\n")
+	assert.Contains(t, insecure, "\nprobe_success 1\n")
+}
+
+// TLS tests
+
+func TestShouldFailOnTlsVerificationWithSelfSignedCert(t *testing.T) {
+	// given
+	srv, _, err := StartServer(port, caFile, key)
+	if err != nil {
+		log.Fatalf("can't start stub server: %v", err)
+	}
+	defer srv.GracefulStop()
+
+	// when
+	stdout, stderr, exitcode := runBin(t, "--tls", stubSrvAddr)
+
+	// then
+	assert.Equal(t, 6, exitcode)
+	assert.Empty(t, stdout)
+	assert.Contains(t, stderr, "TLS handshake failed")
+}
+
+func TestShouldBeAbleToSkipTlsVerification(t *testing.T) {
+	// given
+	srv, _, err := StartServer(port, caFile, key)
+	if err != nil {
+		log.Fatalf("can't start stub server: %v", err)
+	}
+	defer srv.GracefulStop()
+
+	// when
+	stdout, stderr, exitcode := runBin(t, "--tls-insecure", stubSrvAddr)
+
+	// then
+	assert.Equal(t, 0, exitcode)
+	assert.Equal(t, "SERVING\n", stdout)
+	assert.Empty(t, stderr)
+}
+
+func TestShouldBeAbleToSetCustomCAFile(t *testing.T) {
+	// given
+	srv, _, err := StartServer(port, caFile, key)
+	if err != nil {
+		log.Fatalf("can't start stub server: %v", err)
+	}
+	defer srv.GracefulStop()
+
+	// when
+	stdout, stderr, exitcode := runBin(t, "--tls-cafile", caFile, stubSrvAddr)
+
+	// then
+	assert.Equal(t, 0, exitcode)
+	assert.Equal(t, "SERVING\n", stdout)
+	assert.Empty(t, stderr)
+}
+
+func TestShouldBeAbleToSetCustomCAPath(t *testing.T) {
+	// given
+	srv, _, err := StartServer(port, caFile, key)
+	if err != nil {
+		log.Fatalf("can't start stub server: %v", err)
+	}
+	defer srv.GracefulStop()
+
+	// when
+	stdout, stderr, exitcode := runBin(t, "--tls-capath", caPath, stubSrvAddr)
+
+	// then
+	assert.Equal(t, 0, exitcode)
+	assert.Equal(t, "SERVING\n", stdout)
+	assert.Empty(t, stderr)
+}
+
+func TestShouldBeAbleToPresentClientCertificate(t *testing.T) {
+	// given
+	srv, _, err := StartMTLSServer(port, caFile, key, caFile)
+	if err != nil {
+		log.Fatalf("can't start stub server: %v", err)
+	}
+	defer srv.GracefulStop()
+
+	// when
+	stdout, stderr, exitcode := runBin(t, "--tls-insecure", "--tls-cert", caFile, "--tls-key", key, stubSrvAddr)
+
+	// then
+	assert.Equal(t, 0, exitcode)
+	assert.Equal(t, "SERVING\n", stdout)
+	assert.Empty(t, stderr)
+}
+
+func TestShouldFailIfServerRequiresClientCertificate(t *testing.T) {
+	// given
+	srv, _, err := StartMTLSServer(port, caFile, key, caFile)
+	if err != nil {
+		log.Fatalf("can't start stub server: %v", err)
+	}
+	defer srv.GracefulStop()
+
+	// when
+	stdout, stderr, exitcode := runBin(t, "--tls-insecure", stubSrvAddr)
+
+	// then
+	assert.Equal(t, 5, exitcode)
+	assert.Empty(t, stdout)
+	assert.NotEmpty(t, stderr)
+}
+
+func TestShouldFailIfClientCertificateIsUsedWithoutTls(t *testing.T) {
+	// when
+	stdout, stderr, exitcode := runBin(t, "--tls-cert", caFile, "--tls-key", key, stubSrvAddr)
+
+	// then
+	assert.Equal(t, 1, exitcode)
+	assert.Contains(t, stdout, "USAGE")
+	assert.Contains(t, stderr, "client certificate requires one of")
+}
+
+// server identity tests
+
+func TestShouldFailIfServerCertificateDoesNotMatchAddress(t *testing.T) {
+	// given
+	srv, _, err := StartServer(port, sanCert, key)
+	if err != nil {
+		log.Fatalf("can't start stub server: %v", err)
+	}
+	defer srv.GracefulStop()
+
+	// when
+	stdout, stderr, exitcode := runBin(t, "--tls-cafile", sanCert, stubSrvAddr)
+
+	// then
+	assert.Equal(t, 6, exitcode)
+	assert.Empty(t, stdout)
+	assert.Contains(t, stderr, "TLS handshake failed")
+	assert.Contains(t, stderr, "localhost")
+}
+
+func TestShouldVerifyServerCertificateAgainstServerName(t *testing.T) {
+	// given
+	srv, _, err := StartServer(port, sanCert, key)
+	if err != nil {
+		log.Fatalf("can't start stub server: %v", err)
+	}
+	defer srv.GracefulStop()
+
+	// when
+	stdout, stderr, exitcode := runBin(t, "--tls-cafile", sanCert, "--tls-server-name", "app.example.com",
+		stubSrvAddr)
+
+	// then
+	assert.Equal(t, 0, exitcode)
+	assert.Equal(t, "SERVING\n", stdout)
+	assert.Empty(t, stderr)
+}
+
+func TestShouldPassIfServerCertificateHasExpectedSANs(t *testing.T) {
+	// given
+	srv, _, err := StartServer(port, sanCert, key)
+	if err != nil {
+		log.Fatalf("can't start stub server: %v", err)
+	}
+	defer srv.GracefulStop()
+
+	// when
+	stdout, stderr, exitcode := runBin(t, "--tls-insecure", "--tls-expect-san", "app.example.com",
+		"--tls-expect-san", "10.0.0.1", "--tls-expect-san", "spiffe://example.org/ns/default/sa/app", stubSrvAddr)
+
+	// then
+	assert.Equal(t, 0, exitcode)
+	assert.Equal(t, "SERVING\n", stdout)
+	assert.Empty(t, stderr)
+}
+
+func TestShouldFailIfServerCertificateLacksExpectedSAN(t *testing.T) {
+	// given
+	srv, _, err := StartServer(port, sanCert, key)
+	if err != nil {
+		log.Fatalf("can't start stub server: %v", err)
+	}
+	defer srv.GracefulStop()
+
+	// when
+	stdout, stderr, exitcode := runBin(t, "--tls-cafile", sanCert, "--tls-server-name", "app.example.com",
+		"--tls-expect-san", "10.0.0.2", stubSrvAddr)
+
+	// then
+	assert.Equal(t, 6, exitcode)
+	assert.Empty(t, stdout)
+	assert.Contains(t, stderr, "TLS handshake failed: server certificate has no SAN 10.0.0.2")
+}
+
+func TestShouldPassIfServerHasExpectedSPIFFEID(t *testing.T) {
+	// given
+	srv, _, err := StartServer(port, sanCert, key)
+	if err != nil {
+		log.Fatalf("can't start stub server: %v", err)
+	}
+	defer srv.GracefulStop()
+
+	// when
+	stdout, stderr, exitcode := runBin(t, "--tls-insecure", "--tls-expect-spiffe-id",
+		"spiffe://example.org/ns/default/sa/app", stubSrvAddr)
+
+	// then
+	assert.Equal(t, 0, exitcode)
+	assert.Equal(t, "SERVING\n", stdout)
+	assert.Empty(t, stderr)
+}
+
+func TestShouldFailIfServerHasDifferentSPIFFEID(t *testing.T) {
+	// given
+	srv, _, err := StartServer(port, sanCert, key)
+	if err != nil {
+		log.Fatalf("can't start stub server: %v", err)
+	}
+	defer srv.GracefulStop()
+
+	// when
+	stdout, stderr, exitcode := runBin(t, "--tls-insecure", "--tls-expect-spiffe-id",
+		"spiffe://example.org/ns/default/sa/other", stubSrvAddr)
+
+	// then
+	assert.Equal(t, 6, exitcode)
+	assert.Empty(t, stdout)
+	assert.Contains(t, stderr, "TLS handshake failed: server SPIFFE ID spiffe://example.org/ns/default/sa/app "+
+		"doesn't match expected spiffe://example.org/ns/default/sa/other")
+}
+
+// pinning tests
+
+// stubPin is SPKI fingerprint of key.pem shared by all stub server certificates
+const stubPin = "sha256/pG92O9tYUKiRIL4Pv1bibXBilZYbQN/1V74aoX8nttI="
+
+func TestShouldPassIfServerKeyMatchesPinWithoutCAVerification(t *testing.T) {
+	// given
+	srv, _, err := StartServer(port, caFile, key)
+	if err != nil {
+		log.Fatalf("can't start stub server: %v", err)
+	}
+	defer srv.GracefulStop()
+
+	// when
+	stdout, stderr, exitcode := runBin(t, "--tls-insecure", "--tls-pin", stubPin, stubSrvAddr)
+
+	// then
+	assert.Equal(t, 0, exitcode)
+	assert.Equal(t, "SERVING\n", stdout)
+	assert.Empty(t, stderr)
+}
+
+func TestShouldPassIfServerKeyMatchesPinWithCAVerification(t *testing.T) {
+	// given
+	srv, _, err := StartServer(port, sanCert, key)
+	if err != nil {
+		log.Fatalf("can't start stub server: %v", err)
+	}
+	defer srv.GracefulStop()
+
+	// when
+	stdout, stderr, exitcode := runBin(t, "--tls-cafile", sanCert, "--tls-server-name", "app.example.com",
+		"--tls-pin", "sha256/AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA=", "--tls-pin", stubPin, stubSrvAddr)
+
+	// then
+	assert.Equal(t, 0, exitcode)
+	assert.Equal(t, "SERVING\n", stdout)
+	assert.Empty(t, stderr)
+}
+
+func TestShouldFailIfServerKeyDoesNotMatchPin(t *testing.T) {
+	// given
+	srv, _, err := StartServer(port, caFile, key)
+	if err != nil {
+		log.Fatalf("can't start stub server: %v", err)
+	}
+	defer srv.GracefulStop()
+
+	// when
+	stdout, stderr, exitcode := runBin(t, "--tls-insecure", "--tls-pin",
+		"sha256/AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA=", stubSrvAddr)
+
+	// then
+	assert.Equal(t, 6, exitcode)
+	assert.Empty(t, stdout)
+	assert.Equal(t, "TLS handshake failed: no server certificate matches pinned public keys, found "+stubPin+"\n", stderr)
+}
+
+func TestShouldFailIfPinIsInvalid(t *testing.T) {
+	// when
+	stdout, stderr, exitcode := runBin(t, "--tls-insecure", "--tls-pin", "md5/AQID", stubSrvAddr)
+
+	// then
+	assert.Equal(t, 1, exitcode)
+	assert.Contains(t, stdout, "USAGE")
+	assert.Contains(t, stderr, "invalid pin md5/AQID")
+}
+
+// tls-info tests
+
+func TestShouldPrintTLSHandshakeDetails(t *testing.T) {
+	// given
+	srv, _, err := StartServer(port, sanCert, key)
+	if err != nil {
+		log.Fatalf("can't start stub server: %v", err)
+	}
+	defer srv.GracefulStop()
+
+	// when
+	stdout, stderr, exitcode := runBin(t, "tls-info", "--tls-cafile", sanCert, "--tls-server-name", "app.example.com",
+		stubSrvAddr)
+
+	// then
+	assert.Equal(t, 0, exitcode)
+	assert.Empty(t, stderr)
+	assert.Contains(t, stdout, "SNI:             app.example.com\n")
+	assert.Contains(t, stdout, "TLS version:     TLS 1.3\n")
+	assert.Contains(t, stdout, "ALPN protocol:   h2\n")
+	assert.Contains(t, stdout, "Verification:    passed\n")
+	assert.Contains(t, stdout, " 0 Subject:      CN=app.example.com\n")
+	assert.Contains(t, stdout, "   SANs:         app.example.com, 10.0.0.1, spiffe://example.org/ns/default/sa/app\n")
+	assert.Contains(t, stdout, "   Not after:    2054-03-03T07:42:15Z\n")
+	assert.Contains(t, stdout, "   SPKI SHA-256: sha256/pG92O9tYUKiRIL4Pv1bibXBilZYbQN/1V74aoX8nttI=\n")
+}
+
+func TestShouldPrintWhyTLSVerificationFailed(t *testing.T) {
+	// given
+	srv, _, err := StartServer(port, sanCert, key)
+	if err != nil {
+		log.Fatalf("can't start stub server: %v", err)
+	}
+	defer srv.GracefulStop()
+
+	// when
+	stdout, stderr, exitcode := runBin(t, "tls-info", "--tls-cafile", sanCert, stubSrvAddr)
+
+	// then
+	assert.Equal(t, 6, exitcode)
+	assert.Contains(t, stdout, "SNI:             localhost\n")
+	assert.Regexp(t, "Verification:    failed: x509: certificate is valid for app.example.com, not localhost", stdout)
+	assert.Contains(t, stdout, " 0 Subject:      CN=app.example.com\n")
+	assert.Equal(t, "certificate verification failed\n", stderr)
+}
+
+func TestShouldPrintTLSInfoFailureIfServerDoesNotUseTLS(t *testing.T) {
+	// given
+	srv, _, err := StartInsecureServer(port)
+	if err != nil {
+		log.Fatalf("can't start stub server: %v", err)
+	}
+	defer srv.GracefulStop()
+
+	// when
+	stdout, stderr, exitcode := runBin(t, "tls-info", "--tls-insecure", stubSrvAddr)
+
+	// then
+	assert.Equal(t, 6, exitcode)
+	assert.Empty(t, stdout)
+	assert.Contains(t, stderr, "TLS handshake failed")
+}
+
+func TestShouldReturnLegacyExitCodeFromTLSInfoIfRequested(t *testing.T) {
+	// given
+	srv, _, err := StartInsecureServer(port)
+	if err != nil {
+		log.Fatalf("can't start stub server: %v", err)
+	}
+	defer srv.GracefulStop()
+
+	// when
+	_, _, exitcode := runBin(t, "tls-info", "--tls-insecure", "--legacy-exit-codes", stubSrvAddr)
+
+	// then
+	assert.Equal(t, 127, exitcode)
+}
+
+func TestShouldPrintTLSInfoFailureIfServerIsNotListening(t *testing.T) {
+	// when
+	stdout, stderr, exitcode := runBin(t, "tls-info", "--tls-insecure", stubSrvAddr)
+
+	// then
+	assert.Equal(t, 5, exitcode)
+	assert.Empty(t, stdout)
+	assert.Contains(t, stderr, "can't connect to server: dial tcp")
+}
+
+func TestShouldRequireTLSForTLSInfo(t *testing.T) {
+	// when
+	stdout, stderr, exitcode := runBin(t, "tls-info", stubSrvAddr)
+
+	// then
+	assert.Equal(t, 1, exitcode)
+	assert.Contains(t, stdout, "USAGE")
+	assert.Contains(t, stderr, "one of --tls, --tls-insecure, --tls-cafile or --tls-capath is required")
+}
+
+// certificate expiry tests
+
+func TestShouldPassIfServerCertificateIsValidLongEnough(t *testing.T) {
+	// given
+	srv, _, err := StartServer(port, sanCert, key)
+	if err != nil {
+		log.Fatalf("can't start stub server: %v", err)
+	}
+	defer srv.GracefulStop()
+
+	// when
+	stdout, stderr, exitcode := runBin(t, "--tls-insecure", "--tls-min-validity", "720h", "--verbose",
+		stubSrvAddr)
+
+	// then
+	assert.Equal(t, 0, exitcode)
+	assert.Equal(t, "SERVING\n", stdout)
+	assert.Regexp(t, `certificate CN=app.example.com expires in \d+h\d+m0s \(2054-`, stderr)
+}
+
+func TestShouldFailIfServerCertificateExpiresSoon(t *testing.T) {
+	// given
+	srv, _, err := StartServer(port, sanCert, key)
+	if err != nil {
+		log.Fatalf("can't start stub server: %v", err)
+	}
+	defer srv.GracefulStop()
+
+	// when
+	stdout, stderr, exitcode := runBin(t, "--tls-insecure", "--tls-min-validity", "438000h", stubSrvAddr)
+
+	// then
+	assert.Equal(t, 3, exitcode)
+	assert.Equal(t, "SERVING\n", stdout)
+	assert.Regexp(t, `^certificate CN=app.example.com expires in \d+h\d+m0s, required at least 438000h0m0s\n$`, stderr)
+}
+
+func TestShouldPrintCertificateValidityInJSON(t *testing.T) {
+	// given
+	srv, _, err := StartServer(port, sanCert, key)
+	if err != nil {
+		log.Fatalf("can't start stub server: %v", err)
+	}
+	defer srv.GracefulStop()
+
+	// when
+	stdout, _, exitcode := runBin(t, "--tls-insecure", "--tls-min-validity", "438000h", "-o", "json", stubSrvAddr)
+
+	// then
+	assert.Equal(t, 3, exitcode)
+	assert.Regexp(t, `"tls":"insecure","tls_expiry":"2054-[^"]+","tls_validity_seconds":[0-9.e+]+,"exit_code":3`,
+		stdout)
+}
+
+// authentication tests
+
+func TestShouldAuthenticateWithBearerToken(t *testing.T) {
+	// given
+	srv, _, err := StartServer(port, caFile, key, RequireToken("secret"))
+	if err != nil {
+		log.Fatalf("can't start stub server: %v", err)
+	}
+	defer srv.GracefulStop()
+
+	// when
+	stdout, stderr, exitcode := runBin(t, "--tls-insecure", "--token", "secret", stubSrvAddr)
+
+	// then
+	assert.Equal(t, 0, exitcode)
+	assert.Equal(t, "SERVING\n", stdout)
+	assert.Empty(t, stderr)
+}
+
+func TestShouldAuthenticateWithBearerTokenFromFile(t *testing.T) {
+	// given
+	srv, _, err := StartServer(port, caFile, key, RequireToken("secret"))
+	if err != nil {
+		log.Fatalf("can't start stub server: %v", err)
+	}
+	defer srv.GracefulStop()
+	tokenFile := writeTempFile(t, "secret\n")
+	defer os.Remove(tokenFile)
+
+	// when
+	stdout, stderr, exitcode := runBin(t, "--tls-insecure", "--token-file", tokenFile, stubSrvAddr)
+
+	// then
+	assert.Equal(t, 0, exitcode)
+	assert.Equal(t, "SERVING\n", stdout)
+	assert.Empty(t, stderr)
+}
+
+func TestShouldFailIfNotAuthenticated(t *testing.T) {
+	// given
+	srv, _, err := StartServer(port, caFile, key, RequireToken("secret"))
+	if err != nil {
+		log.Fatalf("can't start stub server: %v", err)
+	}
+	defer srv.GracefulStop()
+
+	// when
+	_, missingStderr, missingExitcode := runBin(t, "--tls-insecure", stubSrvAddr)
+	_, invalidStderr, invalidExitcode := runBin(t, "--tls-insecure", "--token", "guess", stubSrvAddr)
+
+	// then
+	assert.Equal(t, 10, missingExitcode)
+	assert.Equal(t, "rpc error: authentication failed: bearer token is missing\n", missingStderr)
+	assert.Equal(t, 10, invalidExitcode)
+	assert.Equal(t, "rpc error: permission denied: invalid bearer token\n", invalidStderr)
+}
+
+func TestShouldAuthenticateWithOAuth2ClientCredentials(t *testing.T) {
+	// given
+	srv, _, err := StartServer(port, caFile, key, RequireToken("secret"))
+	if err != nil {
+		log.Fatalf("can't start stub server: %v", err)
+	}
+	defer srv.GracefulStop()
+	tokenRequests := 0
+	tokenServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tokenRequests++
+		clientID, clientSecret, _ := r.BasicAuth()
+		if r.FormValue("grant_type") != "client_credentials" || clientID != "gprobe" || clientSecret != "s3cret" {
+			http.Error(w, `{"error":"invalid_client"}`, http.StatusUnauthorized)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"access_token":"secret","token_type":"bearer","expires_in":3600}`)
+	}))
+	defer tokenServer.Close()
+
+	// when
+	stdout, stderr, exitcode := runBin(t, "--tls-insecure", "--oauth2-token-url", tokenServer.URL,
+		"--oauth2-client-id", "gprobe", "--oauth2-client-secret", "s3cret", stubSrvAddr)
+
+	// then
+	assert.Equal(t, 0, exitcode)
+	assert.Equal(t, "SERVING\n", stdout)
+	assert.Empty(t, stderr)
+	assert.Equal(t, 1, tokenRequests)
+}
+
+func TestShouldTimeOutIfOAuth2TokenEndpointHangs(t *testing.T) {
+	// given
+	srv, _, err := StartServer(port, caFile, key, RequireToken("secret"))
+	if err != nil {
+		log.Fatalf("can't start stub server: %v", err)
+	}
+	defer srv.GracefulStop()
+	release := make(chan struct{})
+	tokenServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-release:
+		}
+	}))
+	defer tokenServer.Close()
+	defer close(release)
+
+	// when
+	start := time.Now()
+	_, stderr, exitcode := runBin(t, "--tls-insecure", "--timeout", "1s", "--oauth2-token-url", tokenServer.URL,
+		"--oauth2-client-id", "gprobe", stubSrvAddr)
+
+	// then
+	assert.Equal(t, 10, exitcode)
+	assert.Contains(t, stderr, "Client.Timeout exceeded")
+	assert.True(t, time.Since(start) < 5*time.Second, "token request should time out with the check")
+}
+
+func TestShouldCacheTokenFromExecHelper(t *testing.T) {
+	// given
+	srv, _, err := StartServer(port, caFile, key, RequireToken("secret"))
+	if err != nil {
+		log.Fatalf("can't start stub server: %v", err)
+	}
+	defer srv.GracefulStop()
+	dir, err := ioutil.TempDir("", "gprobe-acctest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	invocations := filepath.Join(dir, "invocations")
+	helper := filepath.Join(dir, "get-token.sh")
+	script := "#!/bin/sh\n" +
+		"echo $1 >> " + invocations + "\n" +
+		`echo '{"kind":"ExecCredential","status":{"token":"secret","expirationTimestamp":"2100-01-01T00:00:00Z"}}'` + "\n"
+	err = ioutil.WriteFile(helper, []byte(script), 0700)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	gprobe, wait := startBin(t, "serve-http", "--listen", httpAddr, "--tls-insecure", "--token-exec", helper+" gprobe",
+		stubSrvAddr)
+	defer wait()
+	defer gprobe.Process.Signal(os.Interrupt)
+
+	// when
+	firstStatusCode, _ := httpGet(t, "http://"+httpAddr+"/healthz")
+	secondStatusCode, _ := httpGet(t, "http://"+httpAddr+"/healthz")
+
+	// then
+	assert.Equal(t, 200, firstStatusCode)
+	assert.Equal(t, 200, secondStatusCode)
+	calls, err := ioutil.ReadFile(invocations)
+	assert.NoError(t, err)
+	assert.Equal(t, "gprobe\n", string(calls))
+}
+
+func TestShouldFailIfTokenIsUsedWithoutTls(t *testing.T) {
+	// when
+	_, stderr, exitcode := runBin(t, "--token", "secret", stubSrvAddr)
+
+	// then
+	assert.Equal(t, 1, exitcode)
+	assert.Contains(t, stderr, "require TLS")
+}
+
+// watch tests
+
+func TestWatchShouldExitOnFirstNotServingStatusIfStopOnFailureIsSet(t *testing.T) {
+	// given
+	srv, svc, err := StartInsecureServer(port)
+	if err != nil {
+		log.Fatalf("can't start stub server: %v", err)
+	}
+	defer srv.GracefulStop()
+	svc.SetServingStatus("foo", hv1.HealthCheckResponse_NOT_SERVING)
+
+	// when
+	stdout, stderr, exitcode := runBin(t, "watch", "--stop-on-failure", stubSrvAddr, "foo")
+
+	// then
+	assert.Equal(t, 2, exitcode)
+	assert.Regexp(t, "^\\S+ NOT_SERVING\n$", stdout)
+	assert.Contains(t, stderr, "health-check failed")
+}
+
+func TestWatchShouldPrintStatusTransitions(t *testing.T) {
+	// given
+	srv, svc, err := StartInsecureServer(port)
+	if err != nil {
+		log.Fatalf("can't start stub server: %v", err)
+	}
+	defer srv.GracefulStop()
+	svc.SetServingStatus("foo", hv1.HealthCheckResponse_SERVING)
+
+	// when
+	_, wait := startBin(t, "watch", "--stop-on-failure", stubSrvAddr, "foo")
+	time.Sleep(500 * time.Millisecond)
+	svc.SetServingStatus("foo", hv1.HealthCheckResponse_NOT_SERVING)
+	stdout, _, exitcode := wait()
+
+	// then
+	assert.Equal(t, 2, exitcode)
+	assert.Regexp(t, "^\\S+ SERVING\n\\S+ NOT_SERVING\n$", stdout)
+}
+
+func TestWatchShouldReconnectWhenStreamBreaks(t *testing.T) {
+	// given
+	srv, svc, err := StartInsecureServer(port)
+	if err != nil {
+		log.Fatalf("can't start stub server: %v", err)
+	}
+	svc.SetServingStatus("foo", hv1.HealthCheckResponse_SERVING)
+
+	// when
+	_, wait := startBin(t, "watch", "--stop-on-failure", "--reconnect-interval", "100ms", stubSrvAddr, "foo")
+	time.Sleep(500 * time.Millisecond)
+	srv.Stop()
+	time.Sleep(500 * time.Millisecond)
+	srv, svc, err = StartInsecureServer(port)
+	if err != nil {
+		log.Fatalf("can't restart stub server: %v", err)
+	}
+	defer srv.GracefulStop()
+	svc.SetServingStatus("foo", hv1.HealthCheckResponse_NOT_SERVING)
+	stdout, stderr, exitcode := wait()
+
+	// then
+	assert.Equal(t, 2, exitcode)
+	assert.Regexp(t, "^\\S+ SERVING\n\\S+ NOT_SERVING\n$", stdout)
+	assert.Contains(t, stderr, "reconnecting in 100ms")
+}
+
+func TestWatchShouldFailIfServerDoesNotImplementHealthCheckProtocol(t *testing.T) {
+	// given
+	srv, err := StartEmptyServer(port)
+	if err != nil {
+		log.Fatalf("can't start stub server: %v", err)
+	}
+	defer srv.GracefulStop()
+
+	// when
+	stdout, stderr, exitcode := runBin(t, "watch", stubSrvAddr)
+
+	// then
+	assert.Equal(t, 8, exitcode)
+	assert.Empty(t, stdout)
+	assert.Equal(t, "rpc error: server doesn't implement Health.Watch\n", stderr)
+}
+
+// ping tests
+
+func TestPingShouldCheckGivenNumberOfTimesAndPrintStatistics(t *testing.T) {
+	// given
+	srv, _, err := StartInsecureServer(port)
+	if err != nil {
+		log.Fatalf("can't start stub server: %v", err)
+	}
+	defer srv.GracefulStop()
+
+	// when
+	stdout, stderr, exitcode := runBin(t, "ping", "--count", "3", "--interval", "10ms", "--print-peer", stubSrvAddr)
+
+	// then
+	assert.Equal(t, 0, exitcode)
+	assert.Empty(t, stderr)
+	assert.Regexp(t, `^localhost:\d+: seq=1 peer=127.0.0.1:\d+ SERVING time=[0-9.]+[µnm]?s\n`+
+		`localhost:\d+: seq=2 peer=127.0.0.1:\d+ SERVING time=[0-9.]+[µnm]?s\n`+
+		`localhost:\d+: seq=3 peer=127.0.0.1:\d+ SERVING time=[0-9.]+[µnm]?s\n`+
+		`--- localhost:\d+ ping statistics ---\n`+
+		`3 checks, 3 passed, 100.0% success\n`+
+		`statuses SERVING=3\n`+
+		`latency min/avg/p50/p95/p99/max = \S+/\S+/\S+/\S+/\S+/\S+\n$`, stdout)
+}
+
+func TestPingShouldPrintStatisticsWhenInterrupted(t *testing.T) {
+	// given
+	srv, svc, err := StartInsecureServer(port)
+	if err != nil {
+		log.Fatalf("can't start stub server: %v", err)
+	}
+	defer srv.GracefulStop()
+	svc.SetServingStatus("foo", hv1.HealthCheckResponse_NOT_SERVING)
+	gprobe, wait := startBin(t, "ping", "--interval", "50ms", stubSrvAddr, "foo")
+	time.Sleep(300 * time.Millisecond)
+
+	// when
+	gprobe.Process.Signal(os.Interrupt)
+	stdout, stderr, exitcode := wait()
+
+	// then
+	assert.Equal(t, 2, exitcode)
+	assert.Regexp(t, `localhost:\d+: seq=1 NOT_SERVING time=`, stdout)
+	assert.Regexp(t, `\d+ checks, 0 passed, 0.0% success\nstatuses NOT_SERVING=\d+\n`, stdout)
+	assert.Regexp(t, `^\d+ of \d+ checks failed\n$`, stderr)
+}
+
+func TestPingShouldCountErrors(t *testing.T) {
+	// given
+	srv, _, err := StartInsecureServer(port)
+	if err != nil {
+		log.Fatalf("can't start stub server: %v", err)
+	}
+	defer srv.GracefulStop()
+
+	// when
+	stdout, _, exitcode := runBin(t, "ping", "-c", "2", "-i", "10ms", stubSrvAddr, "my.service.Foo")
+
+	// then
+	assert.Equal(t, 2, exitcode)
+	assert.Contains(t, stdout, "seq=1 rpc error: unknown service my.service.Foo time=")
+	assert.Contains(t, stdout, "2 checks, 0 passed, 0.0% success\nstatuses NotFound=2\n")
+	assert.NotContains(t, stdout, "latency")
+}
+
+// wait tests
+
+func TestWaitShouldExitOnceServiceIsServing(t *testing.T) {
+	// given
+	srv, svc, err := StartInsecureServer(port)
+	if err != nil {
+		log.Fatalf("can't start stub server: %v", err)
+	}
+	defer srv.GracefulStop()
+	svc.SetServingStatus("foo", hv1.HealthCheckResponse_NOT_SERVING)
+
+	// when
+	_, wait := startBin(t, "--wait-for", "SERVING", stubSrvAddr, "foo")
+	time.Sleep(500 * time.Millisecond)
+	svc.SetServingStatus("foo", hv1.HealthCheckResponse_SERVING)
+	stdout, stderr, exitcode := wait()
+
+	// then
+	assert.Equal(t, 0, exitcode)
+	assert.Equal(t, "SERVING\n", stdout)
+	assert.Regexp(t, "^\\S+ waiting for SERVING, status is NOT_SERVING\n\\S+ waiting for SERVING, status is SERVING\n$",
+		stderr)
+}
+
+func TestWaitShouldWaitForDrain(t *testing.T) {
+	// given
+	srv, svc, err := StartInsecureServer(port)
+	if err != nil {
+		log.Fatalf("can't start stub server: %v", err)
+	}
+	defer srv.GracefulStop()
+	svc.SetServingStatus("foo", hv1.HealthCheckResponse_SERVING)
+
+	// when
+	_, wait := startBin(t, "--wait-for", "not_serving", stubSrvAddr, "foo")
+	time.Sleep(500 * time.Millisecond)
+	svc.SetServingStatus("foo", hv1.HealthCheckResponse_NOT_SERVING)
+	stdout, _, exitcode := wait()
+
+	// then
+	assert.Equal(t, 0, exitcode)
+	assert.Equal(t, "NOT_SERVING\n", stdout)
+}
+
+func TestWaitShouldPollIfServerDoesNotImplementWatch(t *testing.T) {
+	// given
+	srv, svc, err := StartCheckOnlyServer(port)
+	if err != nil {
+		log.Fatalf("can't start stub server: %v", err)
+	}
+	defer srv.GracefulStop()
+
+	// when
+	_, wait := startBin(t, "--wait-for", "SERVING", "--interval", "100ms", stubSrvAddr, "foo")
+	time.Sleep(500 * time.Millisecond)
+	svc.SetServingStatus("foo", hv1.HealthCheckResponse_SERVING)
+	stdout, stderr, exitcode := wait()
+
+	// then
+	assert.Equal(t, 0, exitcode)
+	assert.Equal(t, "SERVING\n", stdout)
+	assert.Contains(t, stderr, "server doesn't implement Health.Watch, polling Health.Check every 100ms\n")
+	assert.Contains(t, stderr, "waiting for SERVING, rpc error: unknown service foo\n")
+}
+
+func TestWaitShouldReportUnknownServiceStatusWhenPollingIfNotFoundIsUnknown(t *testing.T) {
+	// given
+	srv, svc, err := StartCheckOnlyServer(port)
+	if err != nil {
+		log.Fatalf("can't start stub server: %v", err)
+	}
+	defer srv.GracefulStop()
+
+	// when
+	_, wait := startBin(t, "--wait-for", "SERVING", "--interval", "100ms", "--not-found-as-unknown", stubSrvAddr, "foo")
+	time.Sleep(500 * time.Millisecond)
+	svc.SetServingStatus("foo", hv1.HealthCheckResponse_SERVING)
+	stdout, stderr, exitcode := wait()
+
+	// then
+	assert.Equal(t, 0, exitcode)
+	assert.Equal(t, "SERVING\n", stdout)
+	assert.Contains(t, stderr, "waiting for SERVING, status is SERVICE_UNKNOWN\n")
+}
+
+func TestWaitShouldTimeOut(t *testing.T) {
+	// given
+	srv, svc, err := StartInsecureServer(port)
+	if err != nil {
+		log.Fatalf("can't start stub server: %v", err)
+	}
+	defer srv.GracefulStop()
+	svc.SetServingStatus("foo", hv1.HealthCheckResponse_NOT_SERVING)
+
+	// when
+	stdout, stderr, exitcode := runBin(t, "--wait-for", "SERVING", "--wait-timeout", "300ms", stubSrvAddr, "foo")
+
+	// then
+	assert.Equal(t, 7, exitcode)
+	assert.Empty(t, stdout)
+	assert.Contains(t, stderr, "timed out waiting for SERVING after 300ms, status is NOT_SERVING\n")
+}
+
+func TestWaitShouldReturnLegacyExitCodeOnTimeout(t *testing.T) {
+	// given
+	srv, svc, err := StartInsecureServer(port)
+	if err != nil {
+		log.Fatalf("can't start stub server: %v", err)
+	}
+	defer srv.GracefulStop()
+	svc.SetServingStatus("foo", hv1.HealthCheckResponse_NOT_SERVING)
+
+	// when
+	_, stderr, exitcode := runBin(t, "--wait-for", "SERVING", "--wait-timeout", "300ms", "--legacy-exit-codes",
+		stubSrvAddr, "foo")
+
+	// then
+	assert.Equal(t, 127, exitcode)
+	assert.Contains(t, stderr, "timed out waiting for SERVING after 300ms")
+}
+
+// monitor tests
+
+func TestMonitorShouldPrintStateTransitions(t *testing.T) {
+	// given
+	srv, svc, err := StartInsecureServer(port)
+	if err != nil {
+		log.Fatalf("can't start stub server: %v", err)
+	}
+	defer srv.GracefulStop()
+	svc.SetServingStatus("foo", hv1.HealthCheckResponse_SERVING)
+	gprobe, wait := startBin(t, "monitor", "--period", "50ms", "--failure-threshold", "2", stubSrvAddr, "foo")
+	time.Sleep(300 * time.Millisecond)
+	svc.SetServingStatus("foo", hv1.HealthCheckResponse_NOT_SERVING)
+	time.Sleep(300 * time.Millisecond)
+
+	// when
+	gprobe.Process.Signal(os.Interrupt)
+	stdout, stderr, exitcode := wait()
+
+	// then
+	assert.Equal(t, 0, exitcode)
+	assert.Empty(t, stderr)
+	assert.Regexp(t, `^\S+ localhost:\d+ foo unknown -> healthy after 1 success: SERVING\n`+
+		`\S+ localhost:\d+ foo healthy -> unhealthy after 2 failures: NOT_SERVING\n$`, stdout)
+}
+
+func TestMonitorShouldRejectInvalidThreshold(t *testing.T) {
+	// when
+	_, stderr, exitcode := runBin(t, "monitor", "--success-threshold", "0", stubSrvAddr)
+
+	// then
+	assert.Equal(t, 1, exitcode)
+	assert.Contains(t, stderr, "--success-threshold and --failure-threshold must be at least 1")
+}
+
+// daemon tests
+
+func TestDaemonShouldProbeTargetsAndKeepStateOnReload(t *testing.T) {
+	// given
+	srv, svc, err := StartInsecureServer(port)
+	if err != nil {
+		log.Fatalf("can't start stub server: %v", err)
+	}
+	defer srv.GracefulStop()
+	svc.SetServingStatus("foo", hv1.HealthCheckResponse_SERVING)
+	svc.SetServingStatus("bar", hv1.HealthCheckResponse_NOT_SERVING)
+	config := writeTempFile(t, fmt.Sprintf("targets:\n- address: %s\n  service: foo\n", stubSrvAddr))
+	defer os.Remove(config)
+	gprobe, wait := startBin(t, "daemon", "--period", "50ms", "--failure-threshold", "1", "--config", config)
+	time.Sleep(300 * time.Millisecond)
+
+	// when
+	err = ioutil.WriteFile(config, []byte(fmt.Sprintf("targets:\n- address: %s\n  service: foo\n  interval: 100ms\n"+
+		"- address: %s\n  service: bar\n", stubSrvAddr, stubSrvAddr)), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gprobe.Process.Signal(syscall.SIGHUP)
+	time.Sleep(300 * time.Millisecond)
+	gprobe.Process.Signal(os.Interrupt)
+	stdout, stderr, exitcode := wait()
+
+	// then
+	assert.Equal(t, 0, exitcode)
+	assert.Regexp(t, `^\S+ localhost:\d+ foo unknown -> healthy after 1 success: SERVING\n`+
+		`\S+ localhost:\d+ bar unknown -> unhealthy after 1 failure: NOT_SERVING\n$`, stdout)
+	assert.Regexp(t, `^\S+ probing 1 targets\n\S+ config reloaded, probing 2 targets\n$`, stderr)
+}
+
+func TestDaemonShouldRequireConfig(t *testing.T) {
+	// when
+	_, stderr, exitcode := runBin(t, "daemon")
+
+	// then
+	assert.Equal(t, 1, exitcode)
+	assert.Contains(t, stderr, "--config is required")
+}
+
+// webhook tests
+
+func TestWatchShouldPostStatusChangesToWebhook(t *testing.T) {
+	// given
+	srv, svc, err := StartInsecureServer(port)
+	if err != nil {
+		log.Fatalf("can't start stub server: %v", err)
+	}
+	defer srv.GracefulStop()
+	svc.SetServingStatus("foo", hv1.HealthCheckResponse_SERVING)
+	events := make(chan string, 10)
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		events <- string(body)
+	}))
+	defer receiver.Close()
+
+	// when
+	_, wait := startBin(t, "watch", "--stop-on-failure", "--webhook", receiver.URL, stubSrvAddr, "foo")
+	time.Sleep(500 * time.Millisecond)
+	svc.SetServingStatus("foo", hv1.HealthCheckResponse_NOT_SERVING)
+	_, _, exitcode := wait()
+	close(events)
+
+	// then
+	assert.Equal(t, 2, exitcode)
+	var received []string
+	for event := range events {
+		received = append(received, event)
+	}
+	assert.Len(t, received, 2)
+	assert.Regexp(t, `^\{"target":"localhost:\d+","service":"foo","previous":"UNKNOWN","current":"SERVING",`+
+		`"timestamp":"\S+"\}$`, received[0])
+	assert.Regexp(t, `^\{"target":"localhost:\d+","service":"foo","previous":"SERVING","current":"NOT_SERVING",`+
+		`"timestamp":"\S+"\}$`, received[1])
+}
+
+func TestDaemonShouldWriteUndeliveredEventsToDeadLetterLog(t *testing.T) {
+	// given
+	srv, svc, err := StartInsecureServer(port)
+	if err != nil {
+		log.Fatalf("can't start stub server: %v", err)
+	}
+	defer srv.GracefulStop()
+	svc.SetServingStatus("foo", hv1.HealthCheckResponse_SERVING)
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer receiver.Close()
+	config := writeTempFile(t, fmt.Sprintf("targets:\n- address: %s\n  service: foo\n", stubSrvAddr))
+	defer os.Remove(config)
+	deadLetter := writeTempFile(t, "")
+	defer os.Remove(deadLetter)
+	gprobe, wait := startBin(t, "daemon", "--period", "50ms", "--config", config, "--webhook", receiver.URL,
+		"--webhook-retries", "1", "--webhook-retry-backoff", "10ms", "--webhook-dead-letter", deadLetter)
+	time.Sleep(300 * time.Millisecond)
+
+	// when
+	gprobe.Process.Signal(os.Interrupt)
+	_, _, exitcode := wait()
+
+	// then
+	assert.Equal(t, 0, exitcode)
+	content, err := ioutil.ReadFile(deadLetter)
+	assert.NoError(t, err)
+	assert.Regexp(t, `^\{"url":"http://127.0.0.1:\d+","error":"webhook responded with 502 Bad Gateway",`+
+		`"event":\{"target":"localhost:\d+","service":"foo","previous":"UNKNOWN","current":"SERVING",`+
+		`"previous_state":"unknown","state":"healthy","timestamp":"\S+"\}\}\n$`, string(content))
+}
+
+func runBin(t *testing.T, args ...string) (stdout string, stderr string, exitcode int) {
+	return runBinWithStdin(t, "", args...)
+}
+
+func runBinWithStdin(t *testing.T, stdin string, args ...string) (stdout string, stderr string, exitcode int) {
+	gprobe := exec.Command(bin, args...)
+	gprobe.Stdin = strings.NewReader(stdin)
+	stdoutPipe, _ := gprobe.StdoutPipe()
+	stderrPipe, _ := gprobe.StderrPipe()
+
+	err := gprobe.Start()
+	if err != nil {
+		t.Error(err)
+	}
+
+	stdout = readPipe(t, stdoutPipe)
+	stderr = readPipe(t, stderrPipe)
+	exitcode = waitForExitCode(t, gprobe)
+
+	return
+}
+
+// startBin starts gprobe in background. Returned function waits for gprobe to exit, gprobe is killed if it
+// doesn't exit within binTimeout
+func startBin(t *testing.T, args ...string) (gprobe *exec.Cmd, wait func() (stdout string, stderr string, exitcode int)) {
+	gprobe = exec.Command(bin, args...)
+	stdoutBuf := new(bytes.Buffer)
+	stderrBuf := new(bytes.Buffer)
+	gprobe.Stdout = stdoutBuf
+	gprobe.Stderr = stderrBuf
+
+	err := gprobe.Start()
+	if err != nil {
+		t.Error(err)
+	}
+	killer := time.AfterFunc(binTimeout, func() {
+		t.Errorf("gprobe didn't exit in %s", binTimeout)
+		gprobe.Process.Kill()
+	})
+
+	return gprobe, func() (string, string, int) {
+		exitcode := waitForExitCode(t, gprobe)
+		killer.Stop()
+		return stdoutBuf.String(), stderrBuf.String(), exitcode
+	}
+}
+
+// writeTempFile writes content into a new temporary file. It is callers responsibility to remove the file
+func writeTempFile(t *testing.T, content string) string {
+	file, err := ioutil.TempFile("", "gprobe-acctest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	_, err = file.WriteString(content)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return file.Name()
+}
+
+// httpGet sends GET request to url, retrying until gprobe starts listening
+func httpGet(t *testing.T, url string) (statusCode int, body string) {
+	var response *http.Response
+	var err error
+	for deadline := time.Now().Add(binTimeout); time.Now().Before(deadline); time.Sleep(50 * time.Millisecond) {
+		response, err = http.Get(url)
+		if err == nil {
+			break
+		}
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer response.Body.Close()
+	return response.StatusCode, readPipe(t, response.Body)
 }
 
 func readPipe(t *testing.T, reader io.Reader) string {
@@ -21,28 +21,134 @@
 package acctest
 
 import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"fmt"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/credentials"
 	"google.golang.org/grpc/health"
 	hv1 "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/reflection"
+	rv1alpha "google.golang.org/grpc/reflection/grpc_reflection_v1alpha"
+	"google.golang.org/grpc/status"
+	"io/ioutil"
 	"net"
+	"strings"
+	"time"
 )
 
 // StartServer starts new gRPC application with simple health service.
 // It is callers responsibility to Stop the server
-func StartServer(port int, certFile string, keyFile string) (*grpc.Server, *health.Server, error) {
+func StartServer(port int, certFile string, keyFile string, options ...grpc.ServerOption) (*grpc.Server, *health.Server, error) {
 	transportCredentials, err := credentials.NewServerTLSFromFile(certFile, keyFile)
 	if err != nil {
 		return nil, nil, err
 	}
+	return doStart(port, append(options, grpc.Creds(transportCredentials))...)
+}
+
+// StartMTLSServer starts new gRPC application with simple health service. The server requires clients to present
+// a certificate signed by CA from clientCAFile.
+// It is callers responsibility to Stop the server
+func StartMTLSServer(port int, certFile string, keyFile string, clientCAFile string) (*grpc.Server, *health.Server, error) {
+	certificate, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, nil, err
+	}
+	clientCA, err := ioutil.ReadFile(clientCAFile)
+	if err != nil {
+		return nil, nil, err
+	}
+	clientCAs := x509.NewCertPool()
+	if !clientCAs.AppendCertsFromPEM(clientCA) {
+		return nil, nil, fmt.Errorf("no certificates found in %s", clientCAFile)
+	}
+	transportCredentials := credentials.NewTLS(&tls.Config{
+		Certificates: []tls.Certificate{certificate},
+		ClientCAs:    clientCAs,
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+	})
 	return doStart(port, grpc.Creds(transportCredentials))
 }
 
 // StartInsecureServer starts new gRPC application with simple health service.
 // It is callers responsibility to Stop the server
-func StartInsecureServer(port int) (*grpc.Server, *health.Server, error) {
-	return doStart(port)
+func StartInsecureServer(port int, options ...grpc.ServerOption) (*grpc.Server, *health.Server, error) {
+	return doStart(port, options...)
+}
+
+// StartUnixServer starts new gRPC application with simple health service listening on unix socket. Socket name
+// starting with @ denotes abstract socket.
+// It is callers responsibility to Stop the server
+func StartUnixServer(socket string, options ...grpc.ServerOption) (*grpc.Server, *health.Server, error) {
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		return nil, nil, err
+	}
+	return doServe(listener, options...)
+}
+
+// RequireAuthority makes server reject calls with :authority header other than given one
+func RequireAuthority(authority string) grpc.ServerOption {
+	return grpc.ChainUnaryInterceptor(func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo,
+		handler grpc.UnaryHandler) (interface{}, error) {
+		md, _ := metadata.FromIncomingContext(ctx)
+		if actual := md.Get(":authority"); len(actual) != 1 || actual[0] != authority {
+			return nil, status.Errorf(codes.PermissionDenied, "unexpected authority %v", actual)
+		}
+		return handler(ctx, req)
+	})
+}
+
+// EchoMetadata makes server send every request metadata entry back in a trailer with the key prefixed by echo-.
+// Reserved headers (content-type, user-agent, :authority, grpc-*) are not echoed
+func EchoMetadata() grpc.ServerOption {
+	return grpc.ChainUnaryInterceptor(func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo,
+		handler grpc.UnaryHandler) (interface{}, error) {
+		md, _ := metadata.FromIncomingContext(ctx)
+		echo := metadata.MD{}
+		for key, values := range md {
+			if key == "content-type" || key == "user-agent" || strings.HasPrefix(key, ":") ||
+				strings.HasPrefix(key, "grpc-") {
+				continue
+			}
+			echo.Append("echo-"+key, values...)
+		}
+		grpc.SetTrailer(ctx, echo)
+		return handler(ctx, req)
+	})
+}
+
+// RequireToken makes server reject calls without given bearer token
+func RequireToken(token string) grpc.ServerOption {
+	return grpc.ChainUnaryInterceptor(func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo,
+		handler grpc.UnaryHandler) (interface{}, error) {
+		md, _ := metadata.FromIncomingContext(ctx)
+		authorization := md.Get("authorization")
+		if len(authorization) == 0 {
+			return nil, status.Error(codes.Unauthenticated, "bearer token is missing")
+		}
+		if authorization[0] != "Bearer "+token {
+			return nil, status.Error(codes.PermissionDenied, "invalid bearer token")
+		}
+		return handler(ctx, req)
+	})
+}
+
+// Delay makes server wait for given duration (or until the call is cancelled) before handling every call
+func Delay(delay time.Duration) grpc.ServerOption {
+	return grpc.ChainUnaryInterceptor(func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo,
+		handler grpc.UnaryHandler) (interface{}, error) {
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		return handler(ctx, req)
+	})
 }
 
 func doStart(port int, options ...grpc.ServerOption) (server *grpc.Server, service *health.Server, err error) {
@@ -50,6 +156,10 @@ func doStart(port int, options ...grpc.ServerOption) (server *grpc.Server, servi
 	if err != nil {
 		return
 	}
+	return doServe(listener, options...)
+}
+
+func doServe(listener net.Listener, options ...grpc.ServerOption) (server *grpc.Server, service *health.Server, err error) {
 	server = grpc.NewServer(options...)
 	service = health.NewServer()
 	hv1.RegisterHealthServer(server, service)
@@ -58,6 +168,26 @@ func doStart(port int, options ...grpc.ServerOption) (server *grpc.Server, servi
 	return server, service, nil
 }
 
+// StartReflectionServer starts new gRPC application with simple health service, server reflection and services with
+// given names. The services have no methods, they are only listed by server reflection.
+// It is callers responsibility to Stop the server
+func StartReflectionServer(port int, services ...string) (server *grpc.Server, service *health.Server, err error) {
+	listener, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
+	if err != nil {
+		return
+	}
+	server = grpc.NewServer()
+	service = health.NewServer()
+	hv1.RegisterHealthServer(server, service)
+	reflection.Register(server)
+	for _, name := range services {
+		server.RegisterService(&grpc.ServiceDesc{ServiceName: name, HandlerType: (*interface{})(nil)}, struct{}{})
+	}
+
+	go server.Serve(listener)
+	return server, service, nil
+}
+
 // StartEmptyServer starts gRPC server application with no services
 func StartEmptyServer(port int) (server *grpc.Server, err error) {
 	listener, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
@@ -69,3 +199,48 @@ func StartEmptyServer(port int) (server *grpc.Server, err error) {
 	go server.Serve(listener)
 	return server, nil
 }
+
+// checkOnlyHealthServer implements Health.Check only, Health.Watch returns Unimplemented
+type checkOnlyHealthServer struct {
+	hv1.UnimplementedHealthServer
+	service *health.Server
+}
+
+func (s *checkOnlyHealthServer) Check(ctx context.Context, req *hv1.HealthCheckRequest) (*hv1.HealthCheckResponse, error) {
+	return s.service.Check(ctx, req)
+}
+
+// StartCheckOnlyServer starts new gRPC application with health service which doesn't implement Health.Watch.
+// Statuses are set through returned health server as usual.
+// It is callers responsibility to Stop the server
+func StartCheckOnlyServer(port int) (server *grpc.Server, service *health.Server, err error) {
+	listener, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
+	if err != nil {
+		return
+	}
+	server = grpc.NewServer()
+	service = health.NewServer()
+	hv1.RegisterHealthServer(server, &checkOnlyHealthServer{service: service})
+
+	go server.Serve(listener)
+	return server, service, nil
+}
+
+// StartLegacyReflectionServer is the same as StartReflectionServer, but it supports server reflection v1alpha only.
+// It is callers responsibility to Stop the server
+func StartLegacyReflectionServer(port int, services ...string) (server *grpc.Server, service *health.Server, err error) {
+	listener, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
+	if err != nil {
+		return
+	}
+	server = grpc.NewServer()
+	service = health.NewServer()
+	hv1.RegisterHealthServer(server, service)
+	rv1alpha.RegisterServerReflectionServer(server, reflection.NewServer(reflection.ServerOptions{Services: server}))
+	for _, name := range services {
+		server.RegisterService(&grpc.ServiceDesc{ServiceName: name, HandlerType: (*interface{})(nil)}, struct{}{})
+	}
+
+	go server.Serve(listener)
+	return server, service, nil
+}
@@ -0,0 +1,170 @@
+// PUBLIC DOMAIN NOTICE
+// National Center for Biotechnology Information
+//
+// This software/database is a "United States Government Work" under the
+// terms of the United States Copyright Act.  It was written as part of
+// the author's official duties as a United States Government employee and
+// thus cannot be copyrighted.  This software/database is freely available
+// to the public for use. The National Library of Medicine and the U.S.
+// Government have not placed any restriction on its use or reproduction.
+//
+// Although all reasonable efforts have been taken to ensure the accuracy
+// and reliability of the software and data, the NLM and the U.S.
+// Government do not and cannot warrant the performance or results that
+// may be obtained by using this software or data. The NLM and the U.S.
+// Government disclaim all warranties, express or implied, including
+// warranties of performance, merchantability or fitness for any particular
+// purpose.
+//
+// Please cite the author in any work or product based on this material.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/urfave/cli"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	hv1 "google.golang.org/grpc/health/grpc_health_v1"
+	rv1 "google.golang.org/grpc/reflection/grpc_reflection_v1"
+	rv1alpha "google.golang.org/grpc/reflection/grpc_reflection_v1alpha"
+	"google.golang.org/grpc/status"
+	"io"
+	"sort"
+	"text/tabwriter"
+	"time"
+)
+
+// internalServices are not checked with --all-services
+var internalServices = map[string]bool{
+	rv1.ServerReflection_ServiceDesc.ServiceName:      true,
+	rv1alpha.ServerReflection_ServiceDesc.ServiceName: true,
+	hv1.Health_ServiceDesc.ServiceName:                true,
+}
+
+// probeAllServices lists services using server reflection and checks health of every one of them the same way as a
+// single service is checked, so retries, status mapping and latency limit apply to every service
+func probeAllServices(w io.Writer, config *appConfig) *cli.ExitError {
+	start := time.Now()
+	services, err := listAllServices(config)
+	if err != nil {
+		exitCode := failureExitCode(config, err)
+		if config.output == outputJSON {
+			printJSONResult(w, config, probeResult{err: err, latency: time.Since(start), attempts: 1}, exitCode)
+		}
+		message := fmt.Sprintf("can't list services: %s", toHumanReadable(err, ""))
+		return cli.NewExitError(message, exitCode)
+	}
+
+	table := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	if config.output != outputJSON {
+		fmt.Fprintln(table, "SERVICE\tSTATUS")
+	}
+	failed, warned := 0, 0
+	for _, service := range services {
+		serviceConfig := *config
+		serviceConfig.serviceName = service
+
+		result := probe(&serviceConfig)
+		exitErr := exitError(&serviceConfig, result)
+		switch exitErr.ExitCode() {
+		case 0:
+		case ExitCodeHealthCheckWarning:
+			warned++
+		default:
+			failed++
+		}
+		if config.output == outputJSON {
+			printJSONResult(w, &serviceConfig, result, exitErr.ExitCode())
+			continue
+		}
+		message := result.status.String()
+		if result.err != nil {
+			message = exitErr.Error()
+		} else if exitErr.ExitCode() == ExitCodeSlowResponse {
+			message = fmt.Sprintf("%s (%s)", message, exitErr.Error())
+		}
+		fmt.Fprintf(table, "%s\t%s\n", service, message)
+	}
+	table.Flush()
+
+	if failed > 0 {
+		message := fmt.Sprintf("health-check failed for %d of %d services", failed, len(services))
+		return cli.NewExitError(message, ExitCodeHealthCheckNegative)
+	}
+	if warned > 0 {
+		message := fmt.Sprintf("health-check warning for %d of %d services", warned, len(services))
+		return cli.NewExitError(message, ExitCodeHealthCheckWarning)
+	}
+	return cli.NewExitError("", 0)
+}
+
+// listAllServices connects to the server and lists services using server reflection within --timeout
+func listAllServices(config *appConfig) ([]string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), config.timeout)
+	defer cancel()
+
+	connection, err := connect(ctx, config)
+	if err != nil {
+		// actually should never happen because we use non-blocking dialer
+		return nil, fmt.Errorf("can't connect to application: %s", err.Error())
+	}
+	defer connection.Close()
+
+	return listServices(ctx, connection)
+}
+
+// listServices returns sorted names of services exposed by server reflection, internal services are skipped.
+// Reflection v1alpha is used if the server doesn't support v1
+func listServices(ctx context.Context, connection *grpc.ClientConn) ([]string, error) {
+	names, err := listReflectedServices(ctx, connection, &rv1.ServerReflection_ServiceDesc)
+	if status.Code(err) == codes.Unimplemented {
+		names, err = listReflectedServices(ctx, connection, &rv1alpha.ServerReflection_ServiceDesc)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var services []string
+	for _, name := range names {
+		if !internalServices[name] {
+			services = append(services, name)
+		}
+	}
+	sort.Strings(services)
+	return services, nil
+}
+
+// listReflectedServices lists services with ServerReflectionInfo stream of reflection service described by desc.
+// Messages of reflection v1 and v1alpha are the same on the wire, so v1 messages are used with both versions
+func listReflectedServices(ctx context.Context, connection *grpc.ClientConn, desc *grpc.ServiceDesc) ([]string,
+	error) {
+	streamDesc := &desc.Streams[0]
+	method := fmt.Sprintf("/%s/%s", desc.ServiceName, streamDesc.StreamName)
+	stream, err := connection.NewStream(ctx, streamDesc, method)
+	if err != nil {
+		return nil, err
+	}
+	err = stream.SendMsg(&rv1.ServerReflectionRequest{
+		MessageRequest: &rv1.ServerReflectionRequest_ListServices{},
+	})
+	if err != nil {
+		return nil, err
+	}
+	response := &rv1.ServerReflectionResponse{}
+	err = stream.RecvMsg(response)
+	if err != nil {
+		return nil, err
+	}
+	stream.CloseSend()
+
+	if errorResponse := response.GetErrorResponse(); errorResponse != nil {
+		return nil, status.Error(codes.Code(errorResponse.ErrorCode), errorResponse.ErrorMessage)
+	}
+	var names []string
+	for _, service := range response.GetListServicesResponse().GetService() {
+		names = append(names, service.Name)
+	}
+	return names, nil
+}
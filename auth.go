@@ -0,0 +1,160 @@
+// PUBLIC DOMAIN NOTICE
+// National Center for Biotechnology Information
+//
+// This software/database is a "United States Government Work" under the
+// terms of the United States Copyright Act.  It was written as part of
+// the author's official duties as a United States Government employee and
+// thus cannot be copyrighted.  This software/database is freely available
+// to the public for use. The National Library of Medicine and the U.S.
+// Government have not placed any restriction on its use or reproduction.
+//
+// Although all reasonable efforts have been taken to ensure the accuracy
+// and reliability of the software and data, the NLM and the U.S.
+// Government do not and cannot warrant the performance or results that
+// may be obtained by using this software or data. The NLM and the U.S.
+// Government disclaim all warranties, express or implied, including
+// warranties of performance, merchantability or fitness for any particular
+// purpose.
+//
+// Please cite the author in any work or product based on this material.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/clientcredentials"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/oauth"
+	"io/ioutil"
+	"net/http"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// tokenCredentials authenticates every call with a bearer token. Token stored in a file is read on every call so
+// that it can be rotated while gprobe is running
+type tokenCredentials struct {
+	token     string
+	tokenFile string
+}
+
+func (creds tokenCredentials) GetRequestMetadata(ctx context.Context, uri ...string) (map[string]string, error) {
+	token := creds.token
+	if len(creds.tokenFile) > 0 {
+		content, err := ioutil.ReadFile(creds.tokenFile)
+		if err != nil {
+			return nil, fmt.Errorf("can't read token: %s", err.Error())
+		}
+		token = strings.TrimSpace(string(content))
+	}
+	return map[string]string{
+		"authorization": "Bearer " + token,
+	}, nil
+}
+
+func (creds tokenCredentials) RequireTransportSecurity() bool {
+	return true
+}
+
+// execCredential is the output of token helper, the format is the same as of kubectl exec credential plugins
+type execCredential struct {
+	Status struct {
+		Token               string    `json:"token"`
+		ExpirationTimestamp time.Time `json:"expirationTimestamp"`
+	} `json:"status"`
+}
+
+// execTokenSource gets token from the output of a helper command. The helper is killed if it runs longer than
+// timeout, zero timeout means no limit
+type execTokenSource struct {
+	command []string
+	timeout time.Duration
+}
+
+func (source execTokenSource) Token() (*oauth2.Token, error) {
+	ctx := context.Background()
+	if source.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, source.timeout)
+		defer cancel()
+	}
+	cmd := exec.CommandContext(ctx, source.command[0], source.command[1:]...)
+	stderr := new(bytes.Buffer)
+	cmd.Stderr = stderr
+	output, err := cmd.Output()
+	if ctx.Err() == context.DeadlineExceeded {
+		return nil, fmt.Errorf("token helper %s timed out after %s", source.command[0], source.timeout)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("token helper %s failed: %s: %s", source.command[0], err.Error(),
+			strings.TrimSpace(stderr.String()))
+	}
+	return parseExecCredential(output)
+}
+
+func parseExecCredential(output []byte) (*oauth2.Token, error) {
+	credential := execCredential{}
+	err := json.Unmarshal(output, &credential)
+	if err != nil {
+		return nil, fmt.Errorf("can't parse token helper output: %s", err.Error())
+	}
+	if len(credential.Status.Token) == 0 {
+		return nil, fmt.Errorf("token helper returned no token")
+	}
+	// zero expiry means the token never expires
+	return &oauth2.Token{
+		AccessToken: credential.Status.Token,
+		Expiry:      credential.Status.ExpirationTimestamp,
+	}, nil
+}
+
+// parsePerRPCCredentials creates credentials attached to every call, at most one authentication method is allowed
+func parsePerRPCCredentials(flags *appFlags) (credentials.PerRPCCredentials, error) {
+	methodsSet := 0
+	for _, value := range []string{flags.token, flags.tokenFile, flags.tokenExec, flags.oauth2TokenURL} {
+		if len(value) > 0 {
+			methodsSet++
+		}
+	}
+	if methodsSet > 1 {
+		return nil, fmt.Errorf("at most one of --token, --token-file, --token-exec and --oauth2-token-url is allowed")
+	}
+
+	switch {
+	case len(flags.token) > 0 || len(flags.tokenFile) > 0:
+		return tokenCredentials{token: flags.token, tokenFile: flags.tokenFile}, nil
+	case len(flags.tokenExec) > 0:
+		command := strings.Fields(flags.tokenExec)
+		if len(command) == 0 {
+			return nil, fmt.Errorf("--token-exec command is empty")
+		}
+		// token is cached until it expires, the helper gets the same time as the whole check
+		source := oauth2.ReuseTokenSource(nil, execTokenSource{command: command, timeout: flags.timeout})
+		return oauth.TokenSource{TokenSource: source}, nil
+	case len(flags.oauth2TokenURL) > 0:
+		if len(flags.oauth2ClientID) == 0 {
+			return nil, fmt.Errorf("--oauth2-client-id is required with --oauth2-token-url")
+		}
+		config := &clientcredentials.Config{
+			ClientID:     flags.oauth2ClientID,
+			ClientSecret: flags.oauth2Secret,
+			TokenURL:     flags.oauth2TokenURL,
+		}
+		for _, scope := range strings.Split(flags.oauth2Scopes, ",") {
+			if scope = strings.TrimSpace(scope); len(scope) > 0 {
+				config.Scopes = append(config.Scopes, scope)
+			}
+		}
+		// token source caches the token and fetches a new one once it expires. Token request isn't bound to the call
+		// context, so it gets the same time as the whole check, as the token helper does
+		ctx := context.WithValue(context.Background(), oauth2.HTTPClient, &http.Client{Timeout: flags.timeout})
+		return oauth.TokenSource{TokenSource: config.TokenSource(ctx)}, nil
+	default:
+		return nil, nil
+	}
+}
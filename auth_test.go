@@ -0,0 +1,167 @@
+// PUBLIC DOMAIN NOTICE
+// National Center for Biotechnology Information
+//
+// This software/database is a "United States Government Work" under the
+// terms of the United States Copyright Act.  It was written as part of
+// the author's official duties as a United States Government employee and
+// thus cannot be copyrighted.  This software/database is freely available
+// to the public for use. The National Library of Medicine and the U.S.
+// Government have not placed any restriction on its use or reproduction.
+//
+// Although all reasonable efforts have been taken to ensure the accuracy
+// and reliability of the software and data, the NLM and the U.S.
+// Government do not and cannot warrant the performance or results that
+// may be obtained by using this software or data. The NLM and the U.S.
+// Government disclaim all warranties, express or implied, including
+// warranties of performance, merchantability or fitness for any particular
+// purpose.
+//
+// Please cite the author in any work or product based on this material.
+
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_parsePerRPCCredentials(t *testing.T) {
+	// given
+	dataset := []struct {
+		flags         *appFlags
+		credsReturned bool
+		errorReturned bool
+		message       string
+	}{
+		// success
+		{&appFlags{}, false, false, "no authentication"},
+		{&appFlags{token: "secret"}, true, false, ""},
+		{&appFlags{tokenFile: "token.txt"}, true, false, ""},
+		{&appFlags{tokenExec: "get-token --audience gprobe"}, true, false, ""},
+		{&appFlags{oauth2TokenURL: "https://localhost/token", oauth2ClientID: "gprobe"}, true, false, ""},
+		// fail
+		{&appFlags{oauth2TokenURL: "https://localhost/token"}, false, true, "client id is required"},
+		{&appFlags{token: "secret", tokenFile: "token.txt"}, false, true, "only one authentication method is allowed"},
+		{&appFlags{token: "secret", oauth2TokenURL: "https://localhost/token"}, false, true, "only one authentication method is allowed"},
+		{&appFlags{tokenExec: "get-token", tokenFile: "token.txt"}, false, true, "only one authentication method is allowed"},
+		{&appFlags{tokenExec: "   "}, false, true, "token helper command is empty"},
+	}
+
+	for _, tt := range dataset {
+		// when
+		creds, err := parsePerRPCCredentials(tt.flags)
+
+		// then
+		if tt.credsReturned {
+			assert.NotNil(t, creds, tt.message)
+		} else {
+			assert.Nil(t, creds, tt.message)
+		}
+		if tt.errorReturned {
+			assert.Error(t, err, tt.message)
+		} else {
+			assert.NoError(t, err, tt.message)
+		}
+	}
+}
+
+func Test_tokenCredentials_tokenFileIsReadOnEveryCall(t *testing.T) {
+	// given
+	file, err := ioutil.TempFile("", "gprobe-token")
+	assert.NoError(t, err)
+	file.Close()
+	defer os.Remove(file.Name())
+	creds := tokenCredentials{tokenFile: file.Name()}
+
+	// when
+	ioutil.WriteFile(file.Name(), []byte("first\n"), 0600)
+	first, firstErr := creds.GetRequestMetadata(context.Background())
+	ioutil.WriteFile(file.Name(), []byte("second\n"), 0600)
+	second, secondErr := creds.GetRequestMetadata(context.Background())
+
+	// then
+	assert.NoError(t, firstErr)
+	assert.NoError(t, secondErr)
+	assert.Equal(t, map[string]string{"authorization": "Bearer first"}, first)
+	assert.Equal(t, map[string]string{"authorization": "Bearer second"}, second)
+}
+
+func Test_parseExecCredential(t *testing.T) {
+	// given
+	output := []byte(`{
+		"apiVersion": "client.authentication.k8s.io/v1",
+		"kind": "ExecCredential",
+		"status": {"token": "secret", "expirationTimestamp": "2018-03-05T17:30:20-08:00"}
+	}`)
+
+	// when
+	token, err := parseExecCredential(output)
+
+	// then
+	assert.NoError(t, err)
+	assert.Equal(t, "secret", token.AccessToken)
+	assert.Equal(t, "2018-03-06T01:30:20Z", token.Expiry.UTC().Format(time.RFC3339))
+}
+
+func Test_parseExecCredential_invalid(t *testing.T) {
+	// given
+	dataset := []string{"", "secret", `{"status": {}}`, `{"status": {"token": "secret", "expirationTimestamp": "tomorrow"}}`}
+
+	for _, output := range dataset {
+		// when
+		_, err := parseExecCredential([]byte(output))
+
+		// then
+		assert.Error(t, err, output)
+	}
+}
+
+func Test_execTokenSource(t *testing.T) {
+	// given
+	source := execTokenSource{command: []string{"echo", `{"status":{"token":"secret"}}`}}
+
+	// when
+	token, err := source.Token()
+
+	// then
+	assert.NoError(t, err)
+	assert.Equal(t, "secret", token.AccessToken)
+	assert.True(t, token.Expiry.IsZero())
+}
+
+func Test_execTokenSource_failure(t *testing.T) {
+	// given
+	source := execTokenSource{command: []string{"false"}}
+
+	// when
+	_, err := source.Token()
+
+	// then
+	assert.Error(t, err)
+}
+
+func Test_execTokenSource_timeout(t *testing.T) {
+	// given
+	source := execTokenSource{command: []string{"sleep", "10"}, timeout: 100 * time.Millisecond}
+
+	// when
+	start := time.Now()
+	_, err := source.Token()
+
+	// then
+	assert.EqualError(t, err, "token helper sleep timed out after 100ms")
+	assert.True(t, time.Since(start) < 5*time.Second, "helper should be killed")
+}
+
+func Test_createConfig_tokenRequiresTLS(t *testing.T) {
+	// when
+	_, err := createConfig(&appFlags{token: "secret"}, []string{"server"})
+
+	// then
+	assert.Error(t, err)
+}
@@ -0,0 +1,456 @@
+// PUBLIC DOMAIN NOTICE
+// National Center for Biotechnology Information
+//
+// This software/database is a "United States Government Work" under the
+// terms of the United States Copyright Act.  It was written as part of
+// the author's official duties as a United States Government employee and
+// thus cannot be copyrighted.  This software/database is freely available
+// to the public for use. The National Library of Medicine and the U.S.
+// Government have not placed any restriction on its use or reproduction.
+//
+// Although all reasonable efforts have been taken to ensure the accuracy
+// and reliability of the software and data, the NLM and the U.S.
+// Government do not and cannot warrant the performance or results that
+// may be obtained by using this software or data. The NLM and the U.S.
+// Government disclaim all warranties, express or implied, including
+// warranties of performance, merchantability or fitness for any particular
+// purpose.
+//
+// Please cite the author in any work or product based on this material.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/urfave/cli"
+	"google.golang.org/grpc"
+	"gopkg.in/yaml.v2"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"reflect"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// targetSettings describes a target in daemon config file. Settings which aren't set are taken from command line
+type targetSettings struct {
+	// Name identifies the target across config reloads, defaults to address and service
+	Name               string        `yaml:"name"`
+	Address            string        `yaml:"address"`
+	Service            string        `yaml:"service"`
+	Interval           time.Duration `yaml:"interval"`
+	InitialDelay       time.Duration `yaml:"initial-delay"`
+	SuccessThreshold   int           `yaml:"success-threshold"`
+	FailureThreshold   int           `yaml:"failure-threshold"`
+	connectionSettings `yaml:",inline"`
+}
+
+// daemonFile is daemon config file layout
+type daemonFile struct {
+	Targets []targetSettings `yaml:"targets"`
+}
+
+// daemonTarget is a target ready to be probed by daemon
+type daemonTarget struct {
+	key      string
+	settings targetSettings
+	config   *appConfig
+	// poolKey identifies connection shared by targets with the same address and connection settings
+	poolKey string
+}
+
+func daemonCommand() cli.Command {
+	flags := &appFlags{}
+	return cli.Command{
+		Name:  "daemon",
+		Usage: "check health of targets listed in config file by schedule and print state transitions",
+		Description: "Every target is checked on its own schedule using kubelet probe semantics, targets with the " +
+			"same address and connection settings share a connection. Command line options are defaults for " +
+			"settings not set in config file. SIGHUP reloads config file keeping state of targets",
+		HideHelp:     true,
+		OnUsageError: onCommandUsageError,
+		Flags: append(append(append(connectionFlags(flags), scheduleFlags(flags)...), webhookFlags(flags)...),
+			cli.StringFlag{
+				Name:        "config, c",
+				Usage:       "Read targets from specified YAML file",
+				Destination: &flags.configFile,
+			},
+		),
+		Action: func(c *cli.Context) error {
+			if len(c.Args()) != 0 {
+				return onCommandUsageError(c, fmt.Errorf("no arguments are allowed"), false)
+			}
+			if len(flags.configFile) == 0 {
+				return onCommandUsageError(c, fmt.Errorf("--config is required"), false)
+			}
+			targets, err := loadDaemonTargets(flags.configFile, flags)
+			if err != nil {
+				return onCommandUsageError(c, err, false)
+			}
+			notifier, err := createNotifier(flags)
+			if err != nil {
+				return onCommandUsageError(c, err, false)
+			}
+			return daemonMain(os.Stdout, flags, targets, notifier)
+		},
+	}
+}
+
+// loadDaemonTargets reads targets from config file and configures them using flags as defaults
+func loadDaemonTargets(path string, defaults *appFlags) ([]daemonTarget, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("can't read config: %s", err.Error())
+	}
+	file := daemonFile{}
+	err = yaml.UnmarshalStrict(content, &file)
+	if err != nil {
+		return nil, fmt.Errorf("can't parse config: %s", err.Error())
+	}
+
+	var targets []daemonTarget
+	keys := map[string]bool{}
+	for i, settings := range file.Targets {
+		target, err := createDaemonTarget(settings, defaults)
+		if err != nil {
+			return nil, fmt.Errorf("target %d: %s", i+1, err.Error())
+		}
+		if keys[target.key] {
+			return nil, fmt.Errorf("target %d: duplicate target %s, set unique name", i+1, target.key)
+		}
+		keys[target.key] = true
+		targets = append(targets, target)
+	}
+	return targets, nil
+}
+
+func createDaemonTarget(settings targetSettings, defaults *appFlags) (target daemonTarget, err error) {
+	if len(settings.Address) == 0 {
+		return target, fmt.Errorf("address is required")
+	}
+	connection := settings.connectionSettings.withDefaults(connectionSettingsOf(defaults))
+	flags := connection.appFlags(defaults)
+	if !connection.hasAuth() {
+		flags.token = defaults.token
+	}
+	flags.initialDelay = defaults.initialDelay
+	if settings.InitialDelay > 0 {
+		flags.initialDelay = settings.InitialDelay
+	}
+	flags.period = defaults.period
+	if settings.Interval > 0 {
+		flags.period = settings.Interval
+	}
+	flags.successThreshold = defaults.successThreshold
+	if settings.SuccessThreshold > 0 {
+		flags.successThreshold = settings.SuccessThreshold
+	}
+	flags.failureThreshold = defaults.failureThreshold
+	if settings.FailureThreshold > 0 {
+		flags.failureThreshold = settings.FailureThreshold
+	}
+
+	config, err := createModule(flags)
+	if err != nil {
+		return target, err
+	}
+	config.schedule, err = createSchedule(flags)
+	if err != nil {
+		return target, err
+	}
+	config.serverAddress = settings.Address
+	config.serviceName = settings.Service
+
+	target.key = settings.Name
+	if len(target.key) == 0 {
+		target.key = strings.TrimSpace(settings.Address + " " + settings.Service)
+	}
+	target.settings = settings
+	target.config = config
+	// timeout is applied to every check, so it doesn't prevent sharing connection
+	connection.Timeout = 0
+	target.poolKey = fmt.Sprintf("%s %v", settings.Address, connection)
+	return target, nil
+}
+
+// connectionSettingsOf converts connection flags into settings
+func connectionSettingsOf(flags *appFlags) connectionSettings {
+	settings := connectionSettings{
+		Timeout:           flags.timeout,
+		TLS:               flags.tls,
+		TLSInsecure:       flags.tlsInsecure,
+		TLSCAFile:         flags.tlsCAFile,
+		TLSCAPath:         flags.tlsCAPath,
+		TLSCertFile:       flags.tlsCertFile,
+		TLSKeyFile:        flags.tlsKeyFile,
+		TLSServerName:     flags.tlsServerName,
+		TLSExpectSAN:      flags.tlsExpectSAN,
+		TLSExpectSPIFFEID: flags.tlsExpectSPIFFEID,
+		TLSPins:           flags.tlsPins,
+		Authority:         flags.authority,
+		Headers:           flags.headers,
+		Token:             flags.token,
+		TokenFile:         flags.tokenFile,
+		TokenExec:         flags.tokenExec,
+	}
+	settings.OAuth2.TokenURL = flags.oauth2TokenURL
+	settings.OAuth2.ClientID = flags.oauth2ClientID
+	settings.OAuth2.ClientSecret = flags.oauth2Secret
+	settings.OAuth2.Scopes = flags.oauth2Scopes
+	return settings
+}
+
+// withDefaults returns settings with values which aren't set taken from defaults. Mutually exclusive settings are
+// taken from defaults as a group: TLS mode only if none of tls, tls-insecure, tls-cafile and tls-capath is set,
+// client certificate only if neither tls-cert nor tls-key is set, and authentication only if no method is set
+func (settings connectionSettings) withDefaults(defaults connectionSettings) connectionSettings {
+	merged := settings
+	if merged.Timeout == 0 {
+		merged.Timeout = defaults.Timeout
+	}
+	if !merged.TLS && !merged.TLSInsecure && len(merged.TLSCAFile) == 0 && len(merged.TLSCAPath) == 0 {
+		merged.TLS = defaults.TLS
+		merged.TLSInsecure = defaults.TLSInsecure
+		merged.TLSCAFile = defaults.TLSCAFile
+		merged.TLSCAPath = defaults.TLSCAPath
+	}
+	if len(merged.TLSCertFile) == 0 && len(merged.TLSKeyFile) == 0 {
+		merged.TLSCertFile = defaults.TLSCertFile
+		merged.TLSKeyFile = defaults.TLSKeyFile
+	}
+	if len(merged.TLSServerName) == 0 {
+		merged.TLSServerName = defaults.TLSServerName
+	}
+	if merged.TLSExpectSAN == nil {
+		merged.TLSExpectSAN = defaults.TLSExpectSAN
+	}
+	if len(merged.TLSExpectSPIFFEID) == 0 {
+		merged.TLSExpectSPIFFEID = defaults.TLSExpectSPIFFEID
+	}
+	if merged.TLSPins == nil {
+		merged.TLSPins = defaults.TLSPins
+	}
+	if len(merged.Authority) == 0 {
+		merged.Authority = defaults.Authority
+	}
+	if merged.Headers == nil {
+		merged.Headers = defaults.Headers
+	}
+	if !merged.hasAuth() {
+		merged.Token = defaults.Token
+		merged.TokenFile = defaults.TokenFile
+		merged.TokenExec = defaults.TokenExec
+		merged.OAuth2 = defaults.OAuth2
+	}
+	return merged
+}
+
+// hasAuth tells if any authentication method is set
+func (settings connectionSettings) hasAuth() bool {
+	return len(settings.Token) > 0 || len(settings.TokenFile) > 0 || len(settings.TokenExec) > 0 ||
+		len(settings.OAuth2.TokenURL) > 0
+}
+
+// connectionPool shares connections between targets, a connection is closed when the last target releases it
+type connectionPool struct {
+	mutex       sync.Mutex
+	connections map[string]*pooledConnection
+	// connect creates new connection, it is replaced in tests
+	connect func(ctx context.Context, config *appConfig) (*grpc.ClientConn, error)
+}
+
+type pooledConnection struct {
+	connection *grpc.ClientConn
+	users      int
+}
+
+func newConnectionPool() *connectionPool {
+	return &connectionPool{
+		connections: map[string]*pooledConnection{},
+		connect: func(ctx context.Context, config *appConfig) (*grpc.ClientConn, error) {
+			return connect(ctx, config)
+		},
+	}
+}
+
+// acquire returns connection identified by key, the connection is created using config if there's no such one
+func (pool *connectionPool) acquire(ctx context.Context, key string, config *appConfig) (*grpc.ClientConn, error) {
+	pool.mutex.Lock()
+	defer pool.mutex.Unlock()
+	pooled, isOpen := pool.connections[key]
+	if !isOpen {
+		connection, err := pool.connect(ctx, config)
+		if err != nil {
+			return nil, err
+		}
+		pooled = &pooledConnection{connection: connection}
+		pool.connections[key] = pooled
+	}
+	pooled.users++
+	return pooled.connection, nil
+}
+
+func (pool *connectionPool) release(key string) {
+	pool.mutex.Lock()
+	defer pool.mutex.Unlock()
+	pooled, isOpen := pool.connections[key]
+	if !isOpen {
+		return
+	}
+	pooled.users--
+	if pooled.users == 0 {
+		pooled.connection.Close()
+		delete(pool.connections, key)
+	}
+}
+
+// runningTarget is a target being probed by daemon
+type runningTarget struct {
+	target daemonTarget
+	runner *runner
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// daemon runs a runner per target
+type daemon struct {
+	w        io.Writer
+	mutex    sync.Mutex
+	notifier *notifier
+	pool     *connectionPool
+	running  map[string]*runningTarget
+}
+
+func newDaemon(w io.Writer, notifier *notifier) *daemon {
+	return &daemon{
+		w:        w,
+		notifier: notifier,
+		pool:     newConnectionPool(),
+		running:  map[string]*runningTarget{},
+	}
+}
+
+// apply starts new targets, stops removed ones and restarts changed ones keeping their state. Connections of new
+// and changed targets are acquired first, so running targets are left intact if any of them fails
+func (d *daemon) apply(ctx context.Context, targets []daemonTarget) error {
+	var changed []daemonTarget
+	var connections []*grpc.ClientConn
+	configured := map[string]bool{}
+	for _, target := range targets {
+		configured[target.key] = true
+		previous, isRunning := d.running[target.key]
+		if isRunning && reflect.DeepEqual(previous.target.settings, target.settings) {
+			continue
+		}
+		connection, err := d.pool.acquire(ctx, target.poolKey, target.config)
+		if err != nil {
+			for _, acquired := range changed {
+				d.pool.release(acquired.poolKey)
+			}
+			return fmt.Errorf("can't connect to %s: %s", target.key, err.Error())
+		}
+		changed = append(changed, target)
+		connections = append(connections, connection)
+	}
+
+	for i, target := range changed {
+		var state *runner
+		previous, isRunning := d.running[target.key]
+		if isRunning {
+			d.stop(previous)
+			d.pool.release(previous.target.poolKey)
+			state = previous.runner
+		}
+		d.start(ctx, target, connections[i], state)
+	}
+	for key, previous := range d.running {
+		if !configured[key] {
+			d.stop(previous)
+			d.pool.release(previous.target.poolKey)
+		}
+	}
+	return nil
+}
+
+// start runs runner of the target over acquired connection, state of the previous runner is carried over if given
+func (d *daemon) start(ctx context.Context, target daemonTarget, connection *grpc.ClientConn, previous *runner) {
+	config := target.config
+	probe := func(ctx context.Context) probeResult {
+		result, _ := pingOnce(ctx, connection, config)
+		return result
+	}
+	r := newRunner(config, probe, func(event transition) {
+		d.mutex.Lock()
+		defer d.mutex.Unlock()
+		printTransition(d.w, config, event)
+		d.notifier.notify(transitionEvent(config, event))
+	})
+	if previous != nil {
+		r.state, r.stateResult = previous.state, previous.stateResult
+		r.successes, r.failures = previous.successes, previous.failures
+	}
+
+	runCtx, cancel := context.WithCancel(ctx)
+	running := &runningTarget{target: target, runner: r, cancel: cancel, done: make(chan struct{})}
+	d.running[target.key] = running
+	go func() {
+		defer close(running.done)
+		r.run(runCtx)
+	}()
+}
+
+// stop stops runner of the target and waits until it is done, the connection is released by caller
+func (d *daemon) stop(running *runningTarget) {
+	running.cancel()
+	<-running.done
+	delete(d.running, running.target.key)
+}
+
+// shutdown stops all the targets and closes connections
+func (d *daemon) shutdown() {
+	for _, running := range d.running {
+		d.stop(running)
+		d.pool.release(running.target.poolKey)
+	}
+}
+
+// daemonMain probes targets until interrupted, config file is reloaded on SIGHUP
+func daemonMain(w io.Writer, flags *appFlags, targets []daemonTarget, notifier *notifier) *cli.ExitError {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	cancelOnInterrupt(cancel)
+	reload := make(chan os.Signal, 1)
+	signal.Notify(reload, syscall.SIGHUP)
+	defer signal.Stop(reload)
+
+	d := newDaemon(w, notifier)
+	defer notifier.close()
+	defer d.shutdown()
+	err := d.apply(ctx, targets)
+	if err != nil {
+		return cli.NewExitError(err.Error(), ExitCodeUnexpected)
+	}
+	fmt.Fprintf(os.Stderr, "%s probing %d targets\n", timestamp(), len(targets))
+
+	for {
+		select {
+		case <-ctx.Done():
+			return cli.NewExitError("", 0)
+		case <-reload:
+		}
+		targets, err = loadDaemonTargets(flags.configFile, flags)
+		if err == nil {
+			err = d.apply(ctx, targets)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s can't reload config: %s\n", timestamp(), err.Error())
+			continue
+		}
+		fmt.Fprintf(os.Stderr, "%s config reloaded, probing %d targets\n", timestamp(), len(targets))
+	}
+}
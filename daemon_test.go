@@ -0,0 +1,216 @@
+// PUBLIC DOMAIN NOTICE
+// National Center for Biotechnology Information
+//
+// This software/database is a "United States Government Work" under the
+// terms of the United States Copyright Act.  It was written as part of
+// the author's official duties as a United States Government employee and
+// thus cannot be copyrighted.  This software/database is freely available
+// to the public for use. The National Library of Medicine and the U.S.
+// Government have not placed any restriction on its use or reproduction.
+//
+// Although all reasonable efforts have been taken to ensure the accuracy
+// and reliability of the software and data, the NLM and the U.S.
+// Government do not and cannot warrant the performance or results that
+// may be obtained by using this software or data. The NLM and the U.S.
+// Government disclaim all warranties, express or implied, including
+// warranties of performance, merchantability or fitness for any particular
+// purpose.
+//
+// Please cite the author in any work or product based on this material.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/connectivity"
+)
+
+func daemonDefaults() *appFlags {
+	return &appFlags{
+		timeout:          time.Second,
+		tlsCAFile:        "acctest/x509/certificate.pem",
+		period:           10 * time.Second,
+		successThreshold: 1,
+		failureThreshold: 3,
+	}
+}
+
+func Test_loadDaemonTargets(t *testing.T) {
+	// when
+	targets, err := loadDaemonTargets("testdata/daemon.yml", daemonDefaults())
+
+	// then
+	assert.NoError(t, err)
+	assert.Len(t, targets, 3)
+
+	assert.Equal(t, "localhost:1234 foo", targets[0].key)
+	assert.Equal(t, "foo", targets[0].config.serviceName)
+	assert.Equal(t, time.Second, targets[0].config.timeout)
+	assert.Equal(t, "cafile", targets[0].config.tlsMode)
+	assert.Equal(t, schedule{period: 5 * time.Second, successThreshold: 1, failureThreshold: 5},
+		targets[0].config.schedule)
+
+	assert.Equal(t, "localhost:1234 bar", targets[1].key)
+	assert.Equal(t, 3*time.Second, targets[1].config.timeout)
+	assert.Equal(t, schedule{period: 10 * time.Second, successThreshold: 1, failureThreshold: 3},
+		targets[1].config.schedule)
+
+	assert.Equal(t, "secure", targets[2].key)
+	assert.Equal(t, "insecure", targets[2].config.tlsMode)
+	assert.Equal(t, []string{"test"}, targets[2].config.metadata.Get("x-env"))
+}
+
+func Test_loadDaemonTargets_sharedConnection(t *testing.T) {
+	// when
+	targets, err := loadDaemonTargets("testdata/daemon.yml", daemonDefaults())
+
+	// then
+	assert.NoError(t, err)
+	assert.Equal(t, targets[0].poolKey, targets[1].poolKey, "should share connection, only timeout differs")
+	assert.NotEqual(t, targets[0].poolKey, targets[2].poolKey, "should not share connection, TLS differs")
+}
+
+func Test_loadDaemonTargets_invalid(t *testing.T) {
+	// given
+	dataset := []struct {
+		path    string
+		message string
+	}{
+		{"testdata/123098.yml", "should fail, config file does not exist"},
+		{"testdata/targets.txt", "should fail, config is not valid YAML"},
+		{"testdata/exporter.yml", "should fail, config has unknown fields"},
+		{"testdata/daemon-duplicate.yml", "should fail, targets are not unique"},
+	}
+
+	for _, tt := range dataset {
+		// when
+		_, err := loadDaemonTargets(tt.path, daemonDefaults())
+
+		// then
+		assert.Error(t, err, tt.message)
+	}
+}
+
+func Test_connectionSettings_withDefaults(t *testing.T) {
+	// given
+	defaults := connectionSettings{Timeout: time.Second, TLS: true, TLSCertFile: "cert.pem", TLSKeyFile: "key.pem",
+		Authority: "app.example.com", TokenFile: "token"}
+	settings := connectionSettings{TLSCAFile: "ca.pem"}
+	settings.OAuth2.TokenURL = "https://auth.example.com/token"
+
+	// when
+	merged := settings.withDefaults(defaults)
+
+	// then
+	expected := connectionSettings{Timeout: time.Second, TLSCAFile: "ca.pem", TLSCertFile: "cert.pem",
+		TLSKeyFile: "key.pem", Authority: "app.example.com"}
+	expected.OAuth2.TokenURL = "https://auth.example.com/token"
+	assert.Equal(t, expected, merged)
+}
+
+func Test_connectionSettings_withDefaults_token(t *testing.T) {
+	// given
+	defaults := connectionSettings{TokenFile: "token"}
+	settings := connectionSettings{Token: "secret"}
+
+	// when
+	merged := settings.withDefaults(defaults)
+
+	// then
+	assert.Equal(t, connectionSettings{Token: "secret"}, merged)
+}
+
+func Test_connectionPool(t *testing.T) {
+	// given
+	pool := newConnectionPool()
+	config := &appConfig{serverAddress: "localhost:1234"}
+
+	// when
+	first, err := pool.acquire(context.Background(), "foo", config)
+	assert.NoError(t, err)
+	second, err := pool.acquire(context.Background(), "foo", config)
+	assert.NoError(t, err)
+	pool.release("foo")
+
+	// then
+	assert.Same(t, first, second)
+	assert.NotEqual(t, connectivity.Shutdown, first.GetState())
+
+	// when
+	pool.release("foo")
+
+	// then
+	assert.Equal(t, connectivity.Shutdown, first.GetState())
+	assert.Empty(t, pool.connections)
+}
+
+// newTestDaemonTarget creates target which is never checked
+func newTestDaemonTarget(t *testing.T, address string, service string) daemonTarget {
+	defaults := daemonDefaults()
+	defaults.initialDelay = time.Hour
+	target, err := createDaemonTarget(targetSettings{Address: address, Service: service}, defaults)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return target
+}
+
+func Test_daemon_apply_keepsStateOfChangedTargets(t *testing.T) {
+	// given
+	d := newDaemon(ioutil.Discard, &notifier{})
+	defer d.shutdown()
+	foo := newTestDaemonTarget(t, "localhost:1234", "foo")
+	assert.NoError(t, d.apply(context.Background(), []daemonTarget{foo}))
+	d.running[foo.key].runner.state = stateHealthy
+	d.running[foo.key].runner.failures = 2
+	changed := foo
+	changed.settings.Interval = time.Minute
+
+	// when
+	err := d.apply(context.Background(), []daemonTarget{changed})
+
+	// then
+	assert.NoError(t, err)
+	assert.Equal(t, time.Minute, d.running[foo.key].target.settings.Interval)
+	assert.Equal(t, stateHealthy, d.running[foo.key].runner.state)
+	assert.Equal(t, 2, d.running[foo.key].runner.failures)
+	assert.Len(t, d.pool.connections, 1)
+}
+
+func Test_daemon_apply_leavesTargetsIntactIfConnectionFails(t *testing.T) {
+	// given
+	d := newDaemon(ioutil.Discard, &notifier{})
+	defer d.shutdown()
+	connect := d.pool.connect
+	d.pool.connect = func(ctx context.Context, config *appConfig) (*grpc.ClientConn, error) {
+		if config.serverAddress == "broken:1234" {
+			return nil, errors.New("broken")
+		}
+		return connect(ctx, config)
+	}
+	foo := newTestDaemonTarget(t, "localhost:1234", "foo")
+	bar := newTestDaemonTarget(t, "localhost:4321", "bar")
+	assert.NoError(t, d.apply(context.Background(), []daemonTarget{foo, bar}))
+	running := d.running[foo.key]
+	changed := foo
+	changed.settings.Interval = time.Minute
+
+	// when
+	err := d.apply(context.Background(), []daemonTarget{changed, newTestDaemonTarget(t, "broken:1234", "baz")})
+
+	// then
+	assert.EqualError(t, err, "can't connect to broken:1234 baz: broken")
+	assert.Len(t, d.running, 2, "removed target should keep running")
+	assert.Same(t, running, d.running[foo.key], "changed target should not be restarted")
+	assert.Len(t, d.pool.connections, 2)
+	for _, pooled := range d.pool.connections {
+		assert.Equal(t, 1, pooled.users)
+	}
+}
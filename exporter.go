@@ -0,0 +1,248 @@
+// PUBLIC DOMAIN NOTICE
+// National Center for Biotechnology Information
+//
+// This software/database is a "United States Government Work" under the
+// terms of the United States Copyright Act.  It was written as part of
+// the author's official duties as a United States Government employee and
+// thus cannot be copyrighted.  This software/database is freely available
+// to the public for use. The National Library of Medicine and the U.S.
+// Government have not placed any restriction on its use or reproduction.
+//
+// Although all reasonable efforts have been taken to ensure the accuracy
+// and reliability of the software and data, the NLM and the U.S.
+// Government do not and cannot warrant the performance or results that
+// may be obtained by using this software or data. The NLM and the U.S.
+// Government disclaim all warranties, express or implied, including
+// warranties of performance, merchantability or fitness for any particular
+// purpose.
+//
+// Please cite the author in any work or product based on this material.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/urfave/cli"
+	"google.golang.org/grpc/status"
+	"gopkg.in/yaml.v2"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"time"
+)
+
+// defaultModule is the name of the module configured with command line flags
+const defaultModule = "default"
+
+// connectionSettings mirrors connection flags in config files
+type connectionSettings struct {
+	Timeout           time.Duration `yaml:"timeout"`
+	TLS               bool          `yaml:"tls"`
+	TLSInsecure       bool          `yaml:"tls-insecure"`
+	TLSCAFile         string        `yaml:"tls-cafile"`
+	TLSCAPath         string        `yaml:"tls-capath"`
+	TLSCertFile       string        `yaml:"tls-cert"`
+	TLSKeyFile        string        `yaml:"tls-key"`
+	TLSServerName     string        `yaml:"tls-server-name"`
+	TLSExpectSAN      []string      `yaml:"tls-expect-san"`
+	TLSExpectSPIFFEID string        `yaml:"tls-expect-spiffe-id"`
+	TLSPins           []string      `yaml:"tls-pin"`
+	Authority         string        `yaml:"authority"`
+	Headers           []string      `yaml:"headers"`
+	Token             string        `yaml:"token"`
+	TokenFile         string        `yaml:"token-file"`
+	TokenExec         string        `yaml:"token-exec"`
+	OAuth2            struct {
+		TokenURL     string `yaml:"token-url"`
+		ClientID     string `yaml:"client-id"`
+		ClientSecret string `yaml:"client-secret"`
+		Scopes       string `yaml:"scopes"`
+	} `yaml:"oauth2"`
+}
+
+// exporterFile is exporter config file layout
+type exporterFile struct {
+	Modules map[string]connectionSettings `yaml:"modules"`
+}
+
+// appFlags converts settings into flags. Timeout is taken from defaults if not set
+func (settings connectionSettings) appFlags(defaults *appFlags) *appFlags {
+	flags := &appFlags{
+		timeout:           settings.Timeout,
+		tls:               settings.TLS,
+		tlsInsecure:       settings.TLSInsecure,
+		tlsCAFile:         settings.TLSCAFile,
+		tlsCAPath:         settings.TLSCAPath,
+		tlsCertFile:       settings.TLSCertFile,
+		tlsKeyFile:        settings.TLSKeyFile,
+		tlsServerName:     settings.TLSServerName,
+		tlsExpectSAN:      settings.TLSExpectSAN,
+		tlsExpectSPIFFEID: settings.TLSExpectSPIFFEID,
+		tlsPins:           settings.TLSPins,
+		authority:         settings.Authority,
+		headers:           settings.Headers,
+		token:             settings.Token,
+		tokenFile:         settings.TokenFile,
+		tokenExec:         settings.TokenExec,
+		oauth2TokenURL:    settings.OAuth2.TokenURL,
+		oauth2ClientID:    settings.OAuth2.ClientID,
+		oauth2Secret:      settings.OAuth2.ClientSecret,
+		oauth2Scopes:      settings.OAuth2.Scopes,
+	}
+	if flags.timeout == 0 {
+		flags.timeout = defaults.timeout
+	}
+	return flags
+}
+
+func exporterCommand() cli.Command {
+	flags := &appFlags{}
+	return cli.Command{
+		Name:  "exporter",
+		Usage: "export health of gRPC applications as Prometheus metrics",
+		Description: "Every request to /probe?target=<server_address>&service=<service_name>&module=<module> checks " +
+			"service health and responds with metrics. Modules are read from config file, module named default " +
+			"is configured with command line options unless defined in config file",
+		HideHelp:     true,
+		OnUsageError: onCommandUsageError,
+		Flags: append(connectionFlags(flags),
+			cli.StringFlag{
+				Name:        "listen, l",
+				Usage:       "HTTP listen address",
+				Destination: &flags.listenAddress,
+				Value:       ":8080",
+			},
+			cli.StringFlag{
+				Name:        "config, c",
+				Usage:       "Read modules from specified YAML file",
+				Destination: &flags.configFile,
+			},
+		),
+		Action: func(c *cli.Context) error {
+			if len(c.Args()) != 0 {
+				return onCommandUsageError(c, fmt.Errorf("no arguments are allowed"), false)
+			}
+			modules, err := createModules(flags)
+			if err != nil {
+				return onCommandUsageError(c, err, false)
+			}
+			return exporterMain(flags.listenAddress, modules)
+		},
+	}
+}
+
+// createModules creates config of every module defined in config file and the default one
+func createModules(flags *appFlags) (map[string]*appConfig, error) {
+	settings := map[string]connectionSettings{}
+	if len(flags.configFile) > 0 {
+		content, err := ioutil.ReadFile(flags.configFile)
+		if err != nil {
+			return nil, fmt.Errorf("can't read config: %s", err.Error())
+		}
+		file := exporterFile{}
+		err = yaml.UnmarshalStrict(content, &file)
+		if err != nil {
+			return nil, fmt.Errorf("can't parse config: %s", err.Error())
+		}
+		settings = file.Modules
+	}
+
+	modules := make(map[string]*appConfig)
+	for name, moduleSettings := range settings {
+		module, err := createModule(moduleSettings.appFlags(flags))
+		if err != nil {
+			return nil, fmt.Errorf("module %s: %s", name, err.Error())
+		}
+		modules[name] = module
+	}
+	if _, isDefined := modules[defaultModule]; !isDefined {
+		module, err := createModule(flags)
+		if err != nil {
+			return nil, err
+		}
+		modules[defaultModule] = module
+	}
+	return modules, nil
+}
+
+func createModule(flags *appFlags) (*appConfig, error) {
+	config := &appConfig{}
+	err := configureConnection(config, flags)
+	if err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+func exporterMain(listenAddress string, modules map[string]*appConfig) *cli.ExitError {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	cancelOnInterrupt(cancel)
+
+	mux := http.NewServeMux()
+	mux.Handle("/probe", probeHandler(modules))
+	server := &http.Server{
+		Addr:    listenAddress,
+		Handler: mux,
+	}
+	go func() {
+		<-ctx.Done()
+		server.Shutdown(context.Background())
+	}()
+
+	fmt.Fprintf(os.Stderr, "exporting metrics on %s\n", listenAddress)
+	err := server.ListenAndServe()
+	if err != http.ErrServerClosed {
+		return cli.NewExitError(fmt.Sprintf("can't serve HTTP: %s", err.Error()), ExitCodeUnexpected)
+	}
+	return cli.NewExitError("", 0)
+}
+
+// probeHandler checks health of the target passed in query parameters and responds with metrics
+func probeHandler(modules map[string]*appConfig) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		target := query.Get("target")
+		if len(target) == 0 {
+			http.Error(w, "target parameter is missing", http.StatusBadRequest)
+			return
+		}
+		moduleName := query.Get("module")
+		if len(moduleName) == 0 {
+			moduleName = defaultModule
+		}
+		module, isDefined := modules[moduleName]
+		if !isDefined {
+			http.Error(w, fmt.Sprintf("unknown module %s", moduleName), http.StatusBadRequest)
+			return
+		}
+
+		config := *module
+		config.serverAddress = target
+		config.serviceName = query.Get("service")
+		result := probe(&config)
+
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		writeMetrics(w, &config, result)
+	})
+}
+
+// writeMetrics writes probe result in Prometheus text format
+func writeMetrics(w io.Writer, config *appConfig, result probeResult) {
+	success := 0
+	if exitError(config, result).ExitCode() == 0 {
+		success = 1
+	}
+	writeGauge(w, "probe_success", "Whether the service is SERVING", float64(success))
+	writeGauge(w, "probe_duration_seconds", "How long the probe took", result.latency.Seconds())
+	writeGauge(w, "probe_error_code", "gRPC status code of the health check, 0 if the check succeeded",
+		float64(status.Code(result.err)))
+	writeGauge(w, "grpc_health_status",
+		"Reported health status: 0 UNKNOWN, 1 SERVING, 2 NOT_SERVING, 3 SERVICE_UNKNOWN", float64(result.status))
+}
+
+func writeGauge(w io.Writer, name string, help string, value float64) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s gauge\n%s %g\n", name, help, name, name, value)
+}
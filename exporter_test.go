@@ -0,0 +1,110 @@
+// PUBLIC DOMAIN NOTICE
+// National Center for Biotechnology Information
+//
+// This software/database is a "United States Government Work" under the
+// terms of the United States Copyright Act.  It was written as part of
+// the author's official duties as a United States Government employee and
+// thus cannot be copyrighted.  This software/database is freely available
+// to the public for use. The National Library of Medicine and the U.S.
+// Government have not placed any restriction on its use or reproduction.
+//
+// Although all reasonable efforts have been taken to ensure the accuracy
+// and reliability of the software and data, the NLM and the U.S.
+// Government do not and cannot warrant the performance or results that
+// may be obtained by using this software or data. The NLM and the U.S.
+// Government disclaim all warranties, express or implied, including
+// warranties of performance, merchantability or fitness for any particular
+// purpose.
+//
+// Please cite the author in any work or product based on this material.
+
+package main
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	hv1 "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+)
+
+func Test_createModules(t *testing.T) {
+	// given
+	flags := &appFlags{configFile: "testdata/exporter.yml", timeout: time.Second, tlsCAFile: "acctest/x509/certificate.pem"}
+
+	// when
+	modules, err := createModules(flags)
+
+	// then
+	assert.NoError(t, err)
+	assert.Len(t, modules, 4)
+	assert.Equal(t, 5*time.Second, modules["insecure"].timeout)
+	assert.Equal(t, "insecure", modules["insecure"].tlsMode)
+	assert.Nil(t, modules["insecure"].perRPCCreds)
+	assert.Equal(t, time.Second, modules["plaintext"].timeout)
+	assert.Nil(t, modules["plaintext"].creds)
+	assert.Equal(t, tokenCredentials{token: "secret"}, modules["authenticated"].perRPCCreds)
+	assert.Equal(t, "cafile", modules[defaultModule].tlsMode)
+}
+
+func Test_createModules_noConfig(t *testing.T) {
+	// when
+	modules, err := createModules(&appFlags{timeout: time.Second})
+
+	// then
+	assert.NoError(t, err)
+	assert.Len(t, modules, 1)
+	assert.Equal(t, "none", modules[defaultModule].tlsMode)
+}
+
+func Test_createModules_invalid(t *testing.T) {
+	// given
+	dataset := []struct {
+		flags   *appFlags
+		message string
+	}{
+		{&appFlags{configFile: "testdata/123098.yml"}, "should fail, config file does not exist"},
+		{&appFlags{configFile: "testdata/targets.txt"}, "should fail, config is not valid YAML"},
+		{&appFlags{configFile: "testdata/exporter-invalid.yml"}, "should fail, module has conflicting TLS options"},
+	}
+
+	for _, tt := range dataset {
+		// when
+		_, err := createModules(tt.flags)
+
+		// then
+		assert.Error(t, err, tt.message)
+	}
+}
+
+func Test_writeMetrics(t *testing.T) {
+	// given
+	result := probeResult{status: hv1.HealthCheckResponse_SERVING, latency: 1500 * time.Millisecond}
+	buf := new(bytes.Buffer)
+
+	// when
+	writeMetrics(buf, &appConfig{}, result)
+
+	// then
+	assert.Contains(t, buf.String(), "# TYPE probe_success gauge\nprobe_success 1\n")
+	assert.Contains(t, buf.String(), "\nprobe_duration_seconds 1.5\n")
+	assert.Contains(t, buf.String(), "\nprobe_error_code 0\n")
+	assert.Contains(t, buf.String(), "\ngrpc_health_status 1\n")
+}
+
+func Test_writeMetrics_error(t *testing.T) {
+	// given
+	result := probeResult{err: status.Error(codes.NotFound, "unknown service")}
+	buf := new(bytes.Buffer)
+
+	// when
+	writeMetrics(buf, &appConfig{}, result)
+
+	// then
+	assert.Contains(t, buf.String(), "\nprobe_success 0\n")
+	assert.Contains(t, buf.String(), "\nprobe_error_code 5\n")
+	assert.Contains(t, buf.String(), "\ngrpc_health_status 0\n")
+}
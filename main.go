@@ -23,6 +23,7 @@ package main
 import (
 	"context"
 	"crypto/tls"
+	"crypto/x509"
 	"fmt"
 	"github.com/hashicorp/go-rootcerts"
 	"github.com/urfave/cli"
@@ -30,8 +31,13 @@ import (
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/credentials"
 	hv1 "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
 	"google.golang.org/grpc/status"
 	"os"
+	"os/signal"
+	"strings"
+	"syscall"
 	"time"
 )
 
@@ -41,29 +47,131 @@ var version string
 const (
 	// ExitCodeUsage is returned if application used incorrectly
 	ExitCodeUsage = 1
-	// ExitCodeHealthCheckNegative is returned if health status is not SERVING
+	// ExitCodeHealthCheckNegative is returned if health status fails the check: is not SERVING or is mapped to fail with
+	// --expect or --map
 	ExitCodeHealthCheckNegative = 2
+	// ExitCodeCertificateExpiring is returned if server certificate expires sooner than --tls-min-validity
+	ExitCodeCertificateExpiring = 3
+	// ExitCodeResolveFailed is returned if server address can't be resolved
+	ExitCodeResolveFailed = 4
+	// ExitCodeConnectionFailed is returned if server refuses connection or drops it
+	ExitCodeConnectionFailed = 5
+	// ExitCodeTLSFailed is returned if TLS handshake fails, e.g. server certificate can't be verified
+	ExitCodeTLSFailed = 6
+	// ExitCodeTimeout is returned if server doesn't respond within --timeout
+	ExitCodeTimeout = 7
+	// ExitCodeUnimplemented is returned if server doesn't implement RPC required for the check
+	ExitCodeUnimplemented = 8
+	// ExitCodeServiceUnknown is returned if server doesn't know the service
+	ExitCodeServiceUnknown = 9
+	// ExitCodeAuthFailed is returned if server rejects credentials
+	ExitCodeAuthFailed = 10
+	// ExitCodeHealthCheckWarning is returned if health status is mapped to warn with --map
+	ExitCodeHealthCheckWarning = 11
+	// ExitCodeSlowResponse is returned if server responds slower than --max-latency
+	ExitCodeSlowResponse = 12
 	// ExitCodeUnexpected is returned if any other error happens
 	ExitCodeUnexpected = 127
 )
 
 // appFlags holds flags passed to application
 type appFlags struct {
-	timeout     time.Duration
-	noFail      bool
-	tls         bool
-	tlsInsecure bool
-	tlsCAFile   string
-	tlsCAPath   string
+	timeout           time.Duration
+	noFail            bool
+	expect            cli.StringSlice
+	statusMap         string
+	notFoundAsUnknown bool
+	tls               bool
+	tlsInsecure       bool
+	tlsCAFile         string
+	tlsCAPath         string
+	tlsCertFile       string
+	tlsKeyFile        string
+	tlsServerName     string
+	tlsExpectSAN      cli.StringSlice
+	tlsExpectSPIFFEID string
+	tlsPins           cli.StringSlice
+	tlsMinValidity    time.Duration
+	maxLatency        time.Duration
+	authority         string
+	headers           cli.StringSlice
+	token             string
+	tokenFile         string
+	tokenExec         string
+	oauth2TokenURL    string
+	oauth2ClientID    string
+	oauth2Secret      string
+	oauth2Scopes      string
+	printHeaders      bool
+	output            string
+	warningLatency    time.Duration
+	criticalLatency   time.Duration
+	targetsFile       string
+	parallelism       int
+	require           string
+	allServices       bool
+	retries           int
+	retryBackoff      time.Duration
+	retryMaxBackoff   time.Duration
+	retryCodes        string
+	verbose           bool
+	legacyExitCodes   bool
+	stopOnFailure     bool
+	reconnectInterval time.Duration
+	listenAddress     string
+	configFile        string
+	count             int
+	interval          time.Duration
+	printPeer         bool
+	waitFor           string
+	waitTimeout       time.Duration
+	initialDelay      time.Duration
+	period            time.Duration
+	successThreshold  int
+	failureThreshold  int
+	webhooks          cli.StringSlice
+	webhookTemplate   string
+	webhookRetries    int
+	webhookBackoff    time.Duration
+	webhookTimeout    time.Duration
+	webhookDeadLetter string
 }
 
 // appConfig holds processed application config
 type appConfig struct {
-	timeout       time.Duration
-	noFail        bool
-	serverAddress string
-	serviceName   string
-	creds         credentials.TransportCredentials
+	timeout           time.Duration
+	noFail            bool
+	statusPolicy      statusPolicy
+	serverAddress     string
+	serviceName       string
+	creds             credentials.TransportCredentials
+	perRPCCreds       credentials.PerRPCCredentials
+	tlsMode           string
+	tlsMinValidity    time.Duration
+	maxLatency        time.Duration
+	authority         string
+	metadata          metadata.MD
+	printHeaders      bool
+	output            string
+	warningLatency    time.Duration
+	criticalLatency   time.Duration
+	targets           []target
+	parallelism       int
+	required          int
+	allServices       bool
+	retry             retryPolicy
+	verbose           bool
+	legacyExitCodes   bool
+	stopOnFailure     bool
+	reconnectInterval time.Duration
+	listenAddress     string
+	count             int
+	interval          time.Duration
+	printPeer         bool
+	waitFor           string
+	waitTimeout       time.Duration
+	schedule          schedule
+	notifier          *notifier
 }
 
 // mainFn is main application business logic
@@ -75,28 +183,165 @@ func createApp(mainFn mainFn) *cli.App {
 
 	app.Name = "gprobe"
 	app.Usage = "universal gRPC health-checker. See https://github.com/grpc/grpc/blob/master/doc/health-checking.md"
-	app.UsageText = "gprobe [options] server_address [service_name]"
+	app.UsageText = "gprobe [options] server_address [service_name]\n   gprobe [options] --targets file"
 	app.Version = version
 	app.HideHelp = true
 	app.OnUsageError = func(context *cli.Context, err error, isSubcommand bool) error {
+		if flags.output == outputNagios {
+			return nagiosUsageError(os.Stdout, err)
+		}
 		cli.ShowAppHelp(context)
 		return cli.NewExitError(err.Error(), ExitCodeUsage)
 	}
 	cli.VersionPrinter = func(c *cli.Context) {
 		fmt.Fprintf(c.App.Writer, "%s\n", c.App.Version)
 	}
-	app.Flags = []cli.Flag{
+	app.Flags = append(connectionFlags(flags),
+		cli.BoolFlag{
+			Name:        "no-fail, n",
+			Usage:       "Do not fail if service status is other than SERVING. Note: this has no effect on server check",
+			Destination: &flags.noFail,
+		},
+		cli.StringSliceFlag{
+			Name:  "expect",
+			Usage: "Health status which passes the check (repeatable), SERVING by default",
+			Value: &flags.expect,
+		},
+		cli.StringFlag{
+			Name:        "map",
+			Usage:       "Comma-separated STATUS=ok|warn|fail outcomes, e.g. NOT_SERVING=warn. Warning exits with code 11",
+			Destination: &flags.statusMap,
+		},
+		cli.BoolFlag{
+			Name:        "not-found-as-unknown",
+			Usage:       "Report unknown service (NotFound error) as SERVICE_UNKNOWN status rather than an error",
+			Destination: &flags.notFoundAsUnknown,
+		},
+		cli.StringFlag{
+			Name:        "output, o, format",
+			Usage:       "Output format: text, json or nagios",
+			Destination: &flags.output,
+			Value:       outputText,
+		},
+		cli.DurationFlag{
+			Name:        "warning",
+			Usage:       "Report WARNING if response time reaches specified duration (requires --output nagios)",
+			Destination: &flags.warningLatency,
+		},
+		cli.DurationFlag{
+			Name:        "critical",
+			Usage:       "Report CRITICAL if response time reaches specified duration (requires --output nagios)",
+			Destination: &flags.criticalLatency,
+		},
+		cli.StringFlag{
+			Name:        "targets, f",
+			Usage:       "Check targets listed in specified file (- for stdin), one 'server_address [service_name]' per line",
+			Destination: &flags.targetsFile,
+		},
+		cli.IntFlag{
+			Name:        "parallelism, p",
+			Usage:       "Maximum number of targets checked concurrently",
+			Destination: &flags.parallelism,
+			Value:       10,
+		},
+		cli.StringFlag{
+			Name:        "require",
+			Usage:       "How many targets must pass for gprobe to succeed: all, any or a number",
+			Destination: &flags.require,
+			Value:       requireAll,
+		},
+		cli.DurationFlag{
+			Name:        "tls-min-validity",
+			Usage:       "Fail with exit code 3 if any server certificate expires sooner than specified duration, e.g. 720h",
+			Destination: &flags.tlsMinValidity,
+		},
+		cli.DurationFlag{
+			Name:        "max-latency",
+			Usage:       "Fail with exit code 12 if connecting and getting health status takes longer than specified duration",
+			Destination: &flags.maxLatency,
+		},
+		cli.BoolFlag{
+			Name:        "all-services, a",
+			Usage:       "Check every service listed by server reflection, fail if any of them is not SERVING",
+			Destination: &flags.allServices,
+		},
+		cli.IntFlag{
+			Name:        "retries, r",
+			Usage:       "Number of times a failed check is retried within --timeout",
+			Destination: &flags.retries,
+		},
+		cli.DurationFlag{
+			Name:        "retry-backoff",
+			Usage:       "Delay before the first retry, doubled (with jitter) on every next one",
+			Destination: &flags.retryBackoff,
+			Value:       100 * time.Millisecond,
+		},
+		cli.DurationFlag{
+			Name:        "retry-max-backoff",
+			Usage:       "Maximum delay between retries",
+			Destination: &flags.retryMaxBackoff,
+			Value:       1 * time.Second,
+		},
+		cli.StringFlag{
+			Name:        "retry-codes",
+			Usage:       "Comma-separated gRPC status codes considered transient",
+			Destination: &flags.retryCodes,
+			Value:       "Unavailable,DeadlineExceeded",
+		},
+		cli.StringFlag{
+			Name:        "wait-for",
+			Usage:       "Wait until the service reports specified status, e.g. SERVING or NOT_SERVING, keeping single connection open",
+			Destination: &flags.waitFor,
+		},
+		cli.DurationFlag{
+			Name:        "wait-timeout",
+			Usage:       "Maximum time to wait with --wait-for",
+			Destination: &flags.waitTimeout,
+			Value:       5 * time.Minute,
+		},
+		cli.DurationFlag{
+			Name:        "interval",
+			Usage:       "Delay between checks with --wait-for if the server doesn't implement Health.Watch",
+			Destination: &flags.interval,
+			Value:       2 * time.Second,
+		},
+		printHeadersFlag(flags),
+		cli.BoolFlag{
+			Name:        "verbose",
+			Usage:       "Print details of every attempt to stderr",
+			Destination: &flags.verbose,
+		},
+		legacyExitCodesFlag(flags),
+	)
+	app.Action = func(c *cli.Context) error {
+		appConfig, err := createConfig(flags, c.Args())
+		if err != nil {
+			return c.App.OnUsageError(c, err, false)
+		}
+		// Pass all input to mainFn
+		return mainFn(appConfig)
+	}
+	app.Commands = []cli.Command{
+		watchCommand(),
+		serveHTTPCommand(),
+		exporterCommand(),
+		tlsInfoCommand(),
+		pingCommand(),
+		monitorCommand(),
+		daemonCommand(),
+	}
+	return app
+}
+
+// connectionFlags returns flags controlling how gprobe connects to the server. They are shared by all commands
+func connectionFlags(flags *appFlags) []cli.Flag {
+	return []cli.Flag{
 		cli.DurationFlag{
 			Name:        "timeout, t",
 			Usage:       "Operation timeout",
 			Destination: &flags.timeout,
 			Value:       1 * time.Second,
 		},
-		cli.BoolFlag{
-			Name:        "no-fail, n",
-			Usage:       "Do not fail if service status is other than SERVING. Note: this has no effect on server check",
-			Destination: &flags.noFail,
-		},
 		cli.BoolFlag{
 			Name:        "tls",
 			Usage:       "Use TLS, verify server with CA certificates installed on this system",
@@ -119,52 +364,272 @@ func createApp(mainFn mainFn) *cli.App {
 			Usage:       "Use TLS, verify server with CA certificates located under specified path",
 			Destination: &flags.tlsCAPath,
 		},
+		cli.StringFlag{
+			Name:        "tls-cert",
+			EnvVar:      "GPROBE_CERT",
+			Usage:       "Present client certificate stored in specified file to the server (requires TLS and --tls-key)",
+			Destination: &flags.tlsCertFile,
+		},
+		cli.StringFlag{
+			Name:        "tls-key",
+			EnvVar:      "GPROBE_KEY",
+			Usage:       "Private key of the client certificate stored in specified file (requires TLS and --tls-cert)",
+			Destination: &flags.tlsKeyFile,
+		},
+		cli.StringFlag{
+			Name:        "tls-server-name",
+			Usage:       "Verify server certificate against specified name instead of server address, also sent as SNI",
+			Destination: &flags.tlsServerName,
+		},
+		cli.StringSliceFlag{
+			Name:  "tls-expect-san",
+			Usage: "Require server certificate to have specified DNS, IP or URI subject alternative name (repeatable)",
+			Value: &flags.tlsExpectSAN,
+		},
+		cli.StringFlag{
+			Name:        "tls-expect-spiffe-id",
+			Usage:       "Require server certificate to hold specified SPIFFE ID, e.g. spiffe://example.org/ns/default/sa/app",
+			Destination: &flags.tlsExpectSPIFFEID,
+		},
+		cli.StringSliceFlag{
+			Name:  "tls-pin",
+			Usage: "Require any server certificate to have public key with specified sha256/<base64> SPKI fingerprint (repeatable)",
+			Value: &flags.tlsPins,
+		},
+		cli.StringFlag{
+			Name:        "authority",
+			Usage:       "Value of :authority header, also used as TLS server name. Derived from server address by default",
+			Destination: &flags.authority,
+		},
+		cli.StringSliceFlag{
+			Name:  "header, H",
+			Usage: "Add key:value metadata to requests, value of binary key (ending with -bin) must be base64 encoded",
+			Value: &flags.headers,
+		},
+		cli.StringFlag{
+			Name:        "token",
+			EnvVar:      "GPROBE_TOKEN",
+			Usage:       "Authenticate with specified bearer token (requires TLS)",
+			Destination: &flags.token,
+		},
+		cli.StringFlag{
+			Name:        "token-file",
+			Usage:       "Authenticate with bearer token stored in specified file, the file is read on every request (requires TLS)",
+			Destination: &flags.tokenFile,
+		},
+		cli.StringFlag{
+			Name: "token-exec",
+			Usage: "Authenticate with bearer token printed by specified command in kubectl exec credential format, " +
+				"the token is cached until it expires (requires TLS)",
+			Destination: &flags.tokenExec,
+		},
+		cli.StringFlag{
+			Name:        "oauth2-token-url",
+			Usage:       "Authenticate with OAuth2 token obtained from specified URL using client credentials grant (requires TLS)",
+			Destination: &flags.oauth2TokenURL,
+		},
+		cli.StringFlag{
+			Name:        "oauth2-client-id",
+			EnvVar:      "GPROBE_OAUTH2_CLIENT_ID",
+			Usage:       "OAuth2 client ID",
+			Destination: &flags.oauth2ClientID,
+		},
+		cli.StringFlag{
+			Name:        "oauth2-client-secret",
+			EnvVar:      "GPROBE_OAUTH2_CLIENT_SECRET",
+			Usage:       "OAuth2 client secret",
+			Destination: &flags.oauth2Secret,
+		},
+		cli.StringFlag{
+			Name:        "oauth2-scopes",
+			Usage:       "Comma-separated OAuth2 scopes",
+			Destination: &flags.oauth2Scopes,
+		},
 	}
-	app.Action = func(c *cli.Context) error {
-		appConfig, err := createConfig(flags, c.Args())
-		if err != nil {
-			return c.App.OnUsageError(c, err, false)
-		}
-		// Pass all input to mainFn
-		return mainFn(appConfig)
+}
+
+// legacyExitCodesFlag is shared by commands which exit on check failure
+func legacyExitCodesFlag(flags *appFlags) cli.Flag {
+	return cli.BoolFlag{
+		Name:        "legacy-exit-codes",
+		Usage:       "Exit with code 127 on any failure other than negative health status, as gprobe 1.1 did",
+		Destination: &flags.legacyExitCodes,
 	}
-	return app
+}
+
+// printHeadersFlag is shared by the default command and watch
+func printHeadersFlag(flags *appFlags) cli.Flag {
+	return cli.BoolFlag{
+		Name:        "print-headers",
+		Usage:       "Print response headers and trailers to stderr",
+		Destination: &flags.printHeaders,
+	}
+}
+
+// onCommandUsageError shows command help and exits with usage error code
+func onCommandUsageError(c *cli.Context, err error, isSubcommand bool) error {
+	cli.ShowCommandHelp(c, c.Command.Name)
+	return cli.NewExitError(err.Error(), ExitCodeUsage)
 }
 
 func createConfig(flags *appFlags, args cli.Args) (config *appConfig, err error) {
 	config = &appConfig{}
-	switch len(args) {
-	case 2:
-		config.serviceName = args.Get(1)
-		config.serverAddress = args.Get(0)
-		break
-	case 1:
-		config.serverAddress = args.Get(0)
-		break
+	if len(flags.targetsFile) > 0 {
+		if len(args) > 0 {
+			return nil, fmt.Errorf("server_address and service_name arguments can't be used with --targets")
+		}
+		if flags.allServices {
+			return nil, fmt.Errorf("--all-services can't be used with --targets")
+		}
+		err = configureTargets(config, flags)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		switch len(args) {
+		case 2:
+			if flags.allServices {
+				return nil, fmt.Errorf("service_name argument can't be used with --all-services")
+			}
+			config.serviceName = args.Get(1)
+			config.serverAddress = args.Get(0)
+			break
+		case 1:
+			config.serverAddress = args.Get(0)
+			break
+		default:
+			return nil, fmt.Errorf("exactly 1 to 2 arguments are required")
+		}
+	}
+
+	err = configureConnection(config, flags)
+	if err != nil {
+		return nil, err
+	}
+
+	if flags.tlsMinValidity > 0 {
+		if config.creds == nil {
+			return nil, fmt.Errorf("--tls-min-validity requires TLS")
+		}
+		if flags.allServices {
+			return nil, fmt.Errorf("--tls-min-validity can't be used with --all-services")
+		}
+		config.tlsMinValidity = flags.tlsMinValidity
+	}
+
+	switch flags.output {
+	case "", outputText, outputJSON:
+		config.output = flags.output
+	case outputNagios:
+		if len(config.targets) > 0 || flags.allServices {
+			return nil, fmt.Errorf("--output nagios can't be used with --targets or --all-services")
+		}
+		config.output = flags.output
 	default:
-		return nil, fmt.Errorf("exactly 1 to 2 arguments are required")
+		return nil, fmt.Errorf("unsupported output format %s", flags.output)
 	}
+	if (flags.warningLatency > 0 || flags.criticalLatency > 0) && config.output != outputNagios {
+		return nil, fmt.Errorf("--warning and --critical require --output nagios")
+	}
+	config.warningLatency = flags.warningLatency
+	config.criticalLatency = flags.criticalLatency
 
-	creds, err := parseCredentials(flags)
+	config.retry, err = createRetryPolicy(flags)
 	if err != nil {
-		return nil, fmt.Errorf("can't parse TLS configuration: %s", err.Error())
+		return nil, err
+	}
+	config.statusPolicy, err = createStatusPolicy(flags)
+	if err != nil {
+		return nil, err
 	}
 
-	config.creds = creds
-	config.timeout = flags.timeout
+	config.printHeaders = flags.printHeaders
+	config.verbose = flags.verbose
+	config.maxLatency = flags.maxLatency
+	config.legacyExitCodes = flags.legacyExitCodes
+	config.allServices = flags.allServices
 	config.noFail = flags.noFail
+	config.stopOnFailure = flags.stopOnFailure
+	config.reconnectInterval = flags.reconnectInterval
+	config.listenAddress = flags.listenAddress
+	config.count = flags.count
+	config.interval = flags.interval
+	config.printPeer = flags.printPeer
+	if len(flags.waitFor) > 0 {
+		if len(config.targets) > 0 || flags.allServices || (len(flags.output) > 0 && flags.output != outputText) {
+			return nil, fmt.Errorf("--wait-for can't be used with --targets, --all-services or --output")
+		}
+		waitFor, err := parseServingStatus(flags.waitFor)
+		if err != nil {
+			return nil, err
+		}
+		if flags.waitTimeout <= 0 || flags.interval <= 0 {
+			return nil, fmt.Errorf("--wait-timeout and --interval must be positive")
+		}
+		config.waitFor = waitFor.String()
+		config.waitTimeout = flags.waitTimeout
+	}
 	return
 }
 
+// configureConnection sets up connection settings shared by all commands
+func configureConnection(config *appConfig, flags *appFlags) error {
+	creds, err := parseCredentials(flags)
+	if err != nil {
+		return fmt.Errorf("can't parse TLS configuration: %s", err.Error())
+	}
+	perRPCCreds, err := parsePerRPCCredentials(flags)
+	if err != nil {
+		return fmt.Errorf("can't parse authentication configuration: %s", err.Error())
+	}
+	if perRPCCreds != nil && creds == nil {
+		return fmt.Errorf("--token, --token-file, --token-exec and --oauth2-token-url require TLS")
+	}
+	md, err := parseHeaders(flags.headers)
+	if err != nil {
+		return err
+	}
+
+	config.timeout = flags.timeout
+	config.creds = creds
+	config.perRPCCreds = perRPCCreds
+	config.tlsMode = tlsMode(flags)
+	config.authority = flags.authority
+	config.metadata = md
+	return nil
+}
+
 func parseCredentials(flags *appFlags) (credentials.TransportCredentials, error) {
 	// rootcerts library accepts both CAFile and CAPath, however handles only one of two, the other is ignored
 	// to avoid ambiguity in behavior we do additional flags validation and explicitly allow only one flag set
-	switch countTLSFlags(flags) {
+	tlsFlagsSet := countTLSFlags(flags)
+	if (len(flags.tlsCertFile) > 0) != (len(flags.tlsKeyFile) > 0) {
+		return nil, fmt.Errorf("--tls-cert and --tls-key must be used together")
+	}
+	if len(flags.tlsCertFile) > 0 && tlsFlagsSet == 0 {
+		return nil, fmt.Errorf("client certificate requires one of --tls, --tls-insecure, --tls-cafile or --tls-capath")
+	}
+	hasIdentityFlags := len(flags.tlsServerName) > 0 || len(flags.tlsExpectSAN) > 0 ||
+		len(flags.tlsExpectSPIFFEID) > 0 || len(flags.tlsPins) > 0
+	if hasIdentityFlags && tlsFlagsSet == 0 {
+		return nil, fmt.Errorf("--tls-server-name, --tls-expect-san, --tls-expect-spiffe-id and --tls-pin require " +
+			"one of --tls, --tls-insecure, --tls-cafile or --tls-capath")
+	}
+	if len(flags.tlsExpectSPIFFEID) > 0 && !strings.HasPrefix(flags.tlsExpectSPIFFEID, "spiffe://") {
+		return nil, fmt.Errorf("invalid SPIFFE ID %s, must start with spiffe://", flags.tlsExpectSPIFFEID)
+	}
+	for _, pin := range flags.tlsPins {
+		if err := validatePin(pin); err != nil {
+			return nil, err
+		}
+	}
+
+	switch tlsFlagsSet {
 	case 0:
 		// no tls
 		return nil, nil
 	case 1:
-		tlsConfig, err := createTLSConfig(flags.tlsCAFile, flags.tlsCAPath, flags.tlsInsecure)
+		tlsConfig, err := createTLSConfig(flags)
 		if err != nil {
 			return nil, err
 		}
@@ -176,6 +641,8 @@ func parseCredentials(flags *appFlags) (credentials.TransportCredentials, error)
 	}
 }
 
+// countTLSFlags counts flags selecting how server certificate is verified. Client certificate flags are not counted
+// as they can be combined with any of them
 func countTLSFlags(flags *appFlags) int {
 	tlsFlagsSet := 0
 	if flags.tls {
@@ -193,17 +660,57 @@ func countTLSFlags(flags *appFlags) int {
 	return tlsFlagsSet
 }
 
-func createTLSConfig(caFile string, caPath string, insecure bool) (tlsConfig *tls.Config, err error) {
+// tlsMode describes how server certificate is verified
+func tlsMode(flags *appFlags) string {
+	switch {
+	case flags.tls:
+		return "system"
+	case flags.tlsInsecure:
+		return "insecure"
+	case len(flags.tlsCAFile) > 0:
+		return "cafile"
+	case len(flags.tlsCAPath) > 0:
+		return "capath"
+	default:
+		return "none"
+	}
+}
+
+func createTLSConfig(flags *appFlags) (tlsConfig *tls.Config, err error) {
 	tlsConfig = &tls.Config{}
 
-	if insecure {
+	if len(flags.tlsCertFile) > 0 {
+		var certificate tls.Certificate
+		certificate, err = tls.LoadX509KeyPair(flags.tlsCertFile, flags.tlsKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("can't load client certificate: %s", err.Error())
+		}
+		tlsConfig.Certificates = []tls.Certificate{certificate}
+	}
+
+	tlsConfig.ServerName = flags.tlsServerName
+	var checks []peerCheck
+	for _, san := range flags.tlsExpectSAN {
+		checks = append(checks, expectSAN(san))
+	}
+	if len(flags.tlsExpectSPIFFEID) > 0 {
+		checks = append(checks, expectSPIFFEID(flags.tlsExpectSPIFFEID))
+	}
+	if len(flags.tlsPins) > 0 {
+		checks = append(checks, expectPin(flags.tlsPins))
+	}
+	if len(checks) > 0 {
+		tlsConfig.VerifyPeerCertificate = verifyPeerCertificate(checks)
+	}
+
+	if flags.tlsInsecure {
 		tlsConfig.InsecureSkipVerify = true
 		return
 	}
 
 	certs := &rootcerts.Config{
-		CAFile: caFile,
-		CAPath: caPath,
+		CAFile: flags.tlsCAFile,
+		CAPath: flags.tlsCAPath,
 	}
 	err = rootcerts.ConfigureTLS(tlsConfig, certs)
 	if err != nil {
@@ -218,68 +725,274 @@ func main() {
 	createApp(appMain).Run(os.Args)
 }
 
+// cancelOnInterrupt calls cancel once the application receives SIGINT or SIGTERM
+func cancelOnInterrupt(cancel context.CancelFunc) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-signals
+		cancel()
+	}()
+}
+
 func appMain(config *appConfig) *cli.ExitError {
+	if config.output == outputNagios {
+		return nagiosMain(os.Stdout, config)
+	}
+	if len(config.waitFor) > 0 {
+		return waitMain(os.Stdout, config)
+	}
+	if len(config.targets) > 0 {
+		return probeTargets(os.Stdout, config)
+	}
+	if config.allServices {
+		return probeAllServices(os.Stdout, config)
+	}
+
+	result := probe(config)
+	exitErr := exitError(config, result)
+	printResult(os.Stdout, config, result, exitErr.ExitCode())
+	return exitErr
+}
+
+// probeResult holds outcome of a single health check
+type probeResult struct {
+	status   hv1.HealthCheckResponse_ServingStatus
+	err      error // raw error, use toHumanReadable to display it
+	latency  time.Duration
+	attempts int
+	// expiringCert is server certificate which expires first, nil if TLS is not used
+	expiringCert *x509.Certificate
+	// timing of the last attempt
+	timing probeTiming
+}
+
+// probe connects to the server and checks service health, retrying transient failures within configured timeout
+func probe(config *appConfig) (result probeResult) {
+	start := time.Now()
+	defer func() {
+		result.latency = time.Since(start)
+	}()
+
 	ctx, cancel := context.WithTimeout(context.Background(), config.timeout)
 	defer cancel()
 
-	connection, err := connect(ctx, config.serverAddress, config.creds)
+	maxAttempts := config.retry.retries + 1
+	backoff := config.retry.backoff
+	for result.attempts = 1; ; result.attempts++ {
+		// time of the next backoff is kept for a retry, the last attempt gets all the time left
+		reserve := backoff
+		if result.attempts == maxAttempts {
+			reserve = 0
+		}
+		attempt := probeOnce(ctx, config, reserve)
+		result.status, result.err = attempt.status, attempt.err
+		result.expiringCert, result.timing = attempt.expiringCert, attempt.timing
+		if result.err == nil {
+			verbosef(config, "attempt %d of %d: %s", result.attempts, maxAttempts, result.status.String())
+			verbosef(config, "timing: %s", result.timing)
+			if result.expiringCert != nil {
+				verbosef(config, "%s (%s)", describeExpiry(result.expiringCert),
+					result.expiringCert.NotAfter.Format(time.RFC3339))
+			}
+			return
+		}
+		verbosef(config, "attempt %d of %d: %s", result.attempts, maxAttempts,
+			toHumanReadable(result.err, config.serviceName))
+		if result.attempts == maxAttempts || !config.retry.isRetryable(result.err) {
+			return
+		}
+
+		delay := jitter(backoff)
+		if deadline, _ := ctx.Deadline(); time.Now().Add(delay).After(deadline) {
+			verbosef(config, "no time left for another attempt")
+			return
+		}
+		time.Sleep(delay)
+		backoff = config.retry.nextBackoff(backoff)
+	}
+}
+
+// probeOnce makes a single attempt to connect and check service health. The attempt gets all the time left except
+// reserve kept for a retry, or all of it if less is left. Only status, error, server certificate expiring first and
+// timing are set in result
+func probeOnce(ctx context.Context, config *appConfig, reserve time.Duration) (result probeResult) {
+	deadline, _ := ctx.Deadline()
+	timeout := time.Until(deadline) - reserve
+	if timeout <= 0 {
+		timeout = time.Until(deadline)
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	var trace *connectionTrace
+	var dialOptions []grpc.DialOption
+	if needsTrace(config) {
+		trace = &connectionTrace{}
+		dialOptions = trace.dialOptions(config)
+	}
+	start := time.Now()
+	connection, err := connect(ctx, config, dialOptions...)
 	if err != nil {
 		// actually should never happen because we use non-blocking dialer and failFast RPC (defaults)
-		return cli.NewExitError(fmt.Sprintf("can't connect to application: %s", err.Error()), ExitCodeUnexpected)
+		result.err = fmt.Errorf("can't connect to application: %s", err.Error())
+		return
 	}
 	defer connection.Close()
 
-	status, err := check(ctx, connection, config.serviceName)
-	if err != nil {
-		return cli.NewExitError(err.Error(), ExitCodeUnexpected)
+	var server peer.Peer
+	rpcStart := time.Now()
+	result.status, result.err = config.statusPolicy.apply(check(ctx, connection, config.serviceName,
+		grpc.Peer(&server)))
+	if trace != nil {
+		result.timing = trace.rpcTiming(rpcStart)
+	} else {
+		result.timing = probeTiming{untraced: time.Since(start)}
 	}
+	if tlsInfo, isTLS := server.AuthInfo.(credentials.TLSInfo); isTLS {
+		result.expiringCert = earliestExpiring(tlsInfo.State.PeerCertificates)
+	}
+	return
+}
 
-	fmt.Fprintln(os.Stdout, status.String())
-	if !(config.noFail || status == hv1.HealthCheckResponse_SERVING) {
+// verbosef prints message prefixed with the server address to stderr if verbose output is enabled
+func verbosef(config *appConfig, format string, a ...interface{}) {
+	if config.verbose {
+		fmt.Fprintf(os.Stderr, "%s: %s\n", config.serverAddress, fmt.Sprintf(format, a...))
+	}
+}
+
+// exitError converts probe result into application exit code and message
+func exitError(config *appConfig, result probeResult) *cli.ExitError {
+	if result.err != nil {
+		return cli.NewExitError(toHumanReadable(result.err, config.serviceName).Error(), failureExitCode(config, result.err))
+	}
+	outcome := config.statusPolicy.outcome(result.status)
+	if config.noFail {
+		outcome = outcomeOK
+	}
+	if outcome == outcomeFail {
 		return cli.NewExitError("health-check failed", ExitCodeHealthCheckNegative)
 	}
+	if config.tlsMinValidity > 0 && result.expiringCert != nil {
+		if validity := time.Until(result.expiringCert.NotAfter); validity < config.tlsMinValidity {
+			message := fmt.Sprintf("%s, required at least %s", describeExpiry(result.expiringCert),
+				config.tlsMinValidity)
+			return cli.NewExitError(message, ExitCodeCertificateExpiring)
+		}
+	}
+	if config.maxLatency > 0 && result.timing.total() > config.maxLatency {
+		message := fmt.Sprintf("response time %s exceeds --max-latency %s", result.timing.total(), config.maxLatency)
+		return cli.NewExitError(message, ExitCodeSlowResponse)
+	}
+	if outcome == outcomeWarn {
+		return cli.NewExitError(fmt.Sprintf("health-check warning: status is %s", result.status.String()),
+			ExitCodeHealthCheckWarning)
+	}
 
 	// for some reason returning nil here causes err == nil to be false in urfave/cli/errors.go:79
 	return cli.NewExitError("", 0)
 }
 
-func connect(ctx context.Context, serverAddress string, creds credentials.TransportCredentials) (connection *grpc.ClientConn, err error) {
-	var dialOption grpc.DialOption
-	if creds == nil {
-		dialOption = grpc.WithInsecure()
+// connect dials the server. Besides host:port, server address may be any target supported by gRPC name resolution,
+// e.g. unix:///run/app.sock or unix-abstract:name
+func connect(ctx context.Context, config *appConfig, extraOptions ...grpc.DialOption) (
+	connection *grpc.ClientConn, err error) {
+	var dialOptions []grpc.DialOption
+	if config.creds == nil {
+		dialOptions = append(dialOptions, grpc.WithInsecure())
 	} else {
-		dialOption = grpc.WithTransportCredentials(creds)
+		dialOptions = append(dialOptions, grpc.WithTransportCredentials(config.creds))
+	}
+	if config.perRPCCreds != nil {
+		dialOptions = append(dialOptions, grpc.WithPerRPCCredentials(config.perRPCCreds))
+	}
+	if len(config.authority) > 0 {
+		dialOptions = append(dialOptions, grpc.WithAuthority(config.authority))
 	}
-	connection, err = grpc.DialContext(ctx, serverAddress, dialOption)
+	dialOptions = append(dialOptions,
+		grpc.WithUnaryInterceptor(metadataUnaryInterceptor(config)),
+		grpc.WithStreamInterceptor(metadataStreamInterceptor(config)),
+	)
+	dialOptions = append(dialOptions, extraOptions...)
+	connection, err = grpc.DialContext(ctx, config.serverAddress, dialOptions...)
 	return
 }
 
-func check(ctx context.Context, connection *grpc.ClientConn, service string) (status hv1.HealthCheckResponse_ServingStatus, err error) {
+func check(ctx context.Context, connection *grpc.ClientConn, service string, opts ...grpc.CallOption) (
+	status hv1.HealthCheckResponse_ServingStatus, err error) {
 	client := hv1.NewHealthClient(connection)
 	response, err := client.Check(ctx, &hv1.HealthCheckRequest{
 		Service: service,
-	})
+	}, opts...)
 
 	if response != nil {
 		status = response.Status
 	}
 
-	err = toHumanReadable(err, service)
-
 	return
 }
 
+// handshakeFailedMessage prefixes TLS errors reported by gRPC transport
+const handshakeFailedMessage = "authentication handshake failed: "
+
+// lookupFailedMessage prefixes name resolution errors reported by gRPC transport
+const lookupFailedMessage = "dial tcp: lookup "
+
+// failureExitCode tells class of the error by exit code. gRPC reports all transport errors as Unavailable with the
+// underlying net or tls error in status message, so these are told apart by message
+func failureExitCode(config *appConfig, err error) int {
+	if config.legacyExitCodes {
+		return ExitCodeUnexpected
+	}
+	rpcStatus := status.Convert(err)
+	switch rpcStatus.Code() {
+	case codes.Unavailable:
+		switch {
+		case strings.Contains(rpcStatus.Message(), handshakeFailedMessage):
+			return ExitCodeTLSFailed
+		case strings.Contains(rpcStatus.Message(), lookupFailedMessage),
+			strings.Contains(rpcStatus.Message(), "produced zero addresses"):
+			return ExitCodeResolveFailed
+		default:
+			return ExitCodeConnectionFailed
+		}
+	case codes.DeadlineExceeded:
+		return ExitCodeTimeout
+	case codes.Unimplemented:
+		return ExitCodeUnimplemented
+	case codes.NotFound:
+		return ExitCodeServiceUnknown
+	case codes.Unauthenticated, codes.PermissionDenied:
+		return ExitCodeAuthFailed
+	default:
+		return ExitCodeUnexpected
+	}
+}
+
 func toHumanReadable(err error, service string) error {
 	code := status.Code(err)
 	switch code {
 	case codes.OK:
 		return err // err is nil
 	case codes.Unavailable:
+		// certificate verification errors are worth showing, they usually point to misconfiguration
+		message := status.Convert(err).Message()
+		if i := strings.Index(message, handshakeFailedMessage); i >= 0 {
+			return fmt.Errorf("TLS handshake failed: %s", strings.TrimSuffix(message[i+len(handshakeFailedMessage):], `"`))
+		}
+		if i := strings.Index(message, lookupFailedMessage); i >= 0 {
+			return fmt.Errorf("can't resolve server address: %s", strings.TrimSuffix(message[i+len("dial tcp: "):], `"`))
+		}
 		return fmt.Errorf("connection refused: application isn't listening or TLS handshake failed")
 	case codes.Unimplemented:
 		return fmt.Errorf("rpc error: server doesn't implement gRPC health-checking protocol")
 	case codes.NotFound:
 		return fmt.Errorf("rpc error: unknown service %s", service)
+	case codes.Unauthenticated:
+		return fmt.Errorf("rpc error: authentication failed: %s", status.Convert(err).Message())
+	case codes.PermissionDenied:
+		return fmt.Errorf("rpc error: permission denied: %s", status.Convert(err).Message())
 	default:
 		if s, isRPCError := status.FromError(err); isRPCError {
 			// display only message from generic rpc errors, hide code
@@ -21,11 +21,16 @@
 package main
 
 import (
+	"crypto/x509"
+	"fmt"
 	"testing"
 	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/urfave/cli"
+	"google.golang.org/grpc/codes"
+	hv1 "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
 )
 
 func Test_createConfig_args_narg1(t *testing.T) {
@@ -80,6 +85,29 @@ func Test_createConfig_args_narg0(t *testing.T) {
 	assert.Error(t, err)
 }
 
+func Test_createConfig_allServices(t *testing.T) {
+	// given
+	flags := &appFlags{allServices: true}
+
+	// when
+	config, err := createConfig(flags, cli.Args{"server"})
+
+	// then
+	assert.NoError(t, err)
+	assert.True(t, config.allServices)
+}
+
+func Test_createConfig_allServices_withServiceName(t *testing.T) {
+	// given
+	flags := &appFlags{allServices: true}
+
+	// when
+	_, err := createConfig(flags, cli.Args{"server", "svc"})
+
+	// then
+	assert.Error(t, err)
+}
+
 func Test_createConfig_flags_empty(t *testing.T) {
 	// given
 	args := cli.Args{"foo"}
@@ -98,9 +126,10 @@ func Test_createConfig_flags(t *testing.T) {
 	// given
 	args := cli.Args{"foo"}
 	flags := &appFlags{
-		tls:     true,
-		noFail:  true,
-		timeout: time.Minute,
+		tls:       true,
+		noFail:    true,
+		timeout:   time.Minute,
+		authority: "app.example.com",
 	}
 
 	// when
@@ -109,10 +138,160 @@ func Test_createConfig_flags(t *testing.T) {
 	// then
 	assert.NoError(t, err)
 	assert.NotNil(t, config.creds)
+	assert.Equal(t, "app.example.com", config.authority)
 	assert.Equal(t, time.Minute, config.timeout)
 	assert.True(t, config.noFail)
 }
 
+func Test_createConfig_output(t *testing.T) {
+	// given
+	dataset := []struct {
+		output        string
+		errorReturned bool
+	}{
+		{"", false},
+		{"text", false},
+		{"json", false},
+		{"xml", true},
+	}
+
+	for _, tt := range dataset {
+		// when
+		config, err := createConfig(&appFlags{output: tt.output}, cli.Args{"foo"})
+
+		// then
+		if tt.errorReturned {
+			assert.Error(t, err, tt.output)
+		} else {
+			assert.NoError(t, err, tt.output)
+			assert.Equal(t, tt.output, config.output)
+		}
+	}
+}
+
+func Test_createConfig_watchFlags(t *testing.T) {
+	// given
+	args := cli.Args{"foo"}
+	flags := &appFlags{
+		stopOnFailure:     true,
+		reconnectInterval: time.Minute,
+	}
+
+	// when
+	config, err := createConfig(flags, args)
+
+	// then
+	assert.NoError(t, err)
+	assert.True(t, config.stopOnFailure)
+	assert.Equal(t, time.Minute, config.reconnectInterval)
+}
+
+func Test_createConfig_tlsMinValidity(t *testing.T) {
+	// given
+	flags := &appFlags{tls: true, tlsMinValidity: 720 * time.Hour}
+
+	// when
+	config, err := createConfig(flags, cli.Args{"foo"})
+
+	// then
+	assert.NoError(t, err)
+	assert.Equal(t, 720*time.Hour, config.tlsMinValidity)
+}
+
+func Test_createConfig_tlsMinValidity_withoutTLS(t *testing.T) {
+	// given
+	flags := &appFlags{tlsMinValidity: 720 * time.Hour}
+
+	// when
+	_, err := createConfig(flags, cli.Args{"foo"})
+
+	// then
+	assert.EqualError(t, err, "--tls-min-validity requires TLS")
+}
+
+func Test_createConfig_waitFor(t *testing.T) {
+	// given
+	flags := &appFlags{waitFor: "not_serving", waitTimeout: time.Minute, interval: time.Second}
+
+	// when
+	config, err := createConfig(flags, cli.Args{"foo"})
+
+	// then
+	assert.NoError(t, err)
+	assert.Equal(t, "NOT_SERVING", config.waitFor)
+	assert.Equal(t, time.Minute, config.waitTimeout)
+	assert.Equal(t, time.Second, config.interval)
+}
+
+func Test_createConfig_waitFor_withJSONOutput(t *testing.T) {
+	// given
+	flags := &appFlags{waitFor: "SERVING", waitTimeout: time.Minute, interval: time.Second, output: outputJSON}
+
+	// when
+	_, err := createConfig(flags, cli.Args{"foo"})
+
+	// then
+	assert.EqualError(t, err, "--wait-for can't be used with --targets, --all-services or --output")
+}
+
+func Test_exitError_certificateExpiring(t *testing.T) {
+	// given
+	config := &appConfig{tlsMinValidity: 720 * time.Hour}
+	dataset := []struct {
+		notAfter time.Time
+		exitCode int
+	}{
+		{time.Now().Add(721 * time.Hour), 0},
+		{time.Now().Add(719 * time.Hour), ExitCodeCertificateExpiring},
+		{time.Now().Add(-time.Hour), ExitCodeCertificateExpiring},
+	}
+
+	for _, tt := range dataset {
+		result := probeResult{
+			status:       hv1.HealthCheckResponse_SERVING,
+			expiringCert: &x509.Certificate{NotAfter: tt.notAfter},
+		}
+
+		// when
+		exitErr := exitError(config, result)
+
+		// then
+		assert.Equal(t, tt.exitCode, exitErr.ExitCode(), tt.notAfter.String())
+	}
+}
+
+func Test_exitError_statusPolicy(t *testing.T) {
+	// given
+	policy, _ := createStatusPolicy(&appFlags{statusMap: "NOT_SERVING=warn"})
+	config := &appConfig{statusPolicy: policy}
+
+	// when
+	servingExitErr := exitError(config, probeResult{status: hv1.HealthCheckResponse_SERVING})
+	notServingExitErr := exitError(config, probeResult{status: hv1.HealthCheckResponse_NOT_SERVING})
+	unknownExitErr := exitError(config, probeResult{status: hv1.HealthCheckResponse_SERVICE_UNKNOWN})
+
+	// then
+	assert.Equal(t, 0, servingExitErr.ExitCode())
+	assert.Equal(t, ExitCodeHealthCheckWarning, notServingExitErr.ExitCode())
+	assert.Equal(t, "health-check warning: status is NOT_SERVING", notServingExitErr.Error())
+	assert.Equal(t, ExitCodeHealthCheckNegative, unknownExitErr.ExitCode())
+}
+
+func Test_exitError_healthCheckFailureTakesPrecedenceOverExpiry(t *testing.T) {
+	// given
+	config := &appConfig{tlsMinValidity: 720 * time.Hour}
+	result := probeResult{
+		status:       hv1.HealthCheckResponse_NOT_SERVING,
+		expiringCert: &x509.Certificate{NotAfter: time.Now()},
+	}
+
+	// when
+	exitErr := exitError(config, result)
+
+	// then
+	assert.Equal(t, ExitCodeHealthCheckNegative, exitErr.ExitCode())
+}
+
 func Test_parseCredentials_tls(t *testing.T) {
 	// given
 	dataset := []struct {
@@ -126,6 +305,14 @@ func Test_parseCredentials_tls(t *testing.T) {
 		{&appFlags{tlsInsecure: true}, true, false, ""},
 		{&appFlags{tlsCAFile: "acctest/x509/certificate.pem"}, true, false, ""},
 		{&appFlags{tlsCAPath: "acctest/x509"}, true, false, ""},
+		{&appFlags{tls: true, tlsCertFile: "acctest/x509/certificate.pem", tlsKeyFile: "acctest/key.pem"}, true, false, ""},
+		{&appFlags{tlsInsecure: true, tlsCertFile: "acctest/x509/certificate.pem", tlsKeyFile: "acctest/key.pem"}, true, false, ""},
+		{&appFlags{tlsCAFile: "acctest/x509/certificate.pem", tlsCertFile: "acctest/x509/certificate.pem", tlsKeyFile: "acctest/key.pem"}, true, false, ""},
+		{&appFlags{tlsCAPath: "acctest/x509", tlsCertFile: "acctest/x509/certificate.pem", tlsKeyFile: "acctest/key.pem"}, true, false, ""},
+		{&appFlags{tls: true, tlsServerName: "app.example.com"}, true, false, ""},
+		{&appFlags{tlsInsecure: true, tlsExpectSAN: []string{"app.example.com", "10.0.0.1"}}, true, false, ""},
+		{&appFlags{tls: true, tlsExpectSPIFFEID: "spiffe://example.org/ns/default/sa/app"}, true, false, ""},
+		{&appFlags{tlsInsecure: true, tlsPins: []string{"sha256/pG92O9tYUKiRIL4Pv1bibXBilZYbQN/1V74aoX8nttI="}}, true, false, ""},
 		// fail
 		{&appFlags{tlsCAFile: "acctest/key.pem"}, false, true, "should fail, acctest/key.pem is not a valid certificate"},
 		{&appFlags{tlsCAFile: "123098.pem"}, false, true, "should fail, 123098.pem does not exist"},
@@ -133,6 +320,16 @@ func Test_parseCredentials_tls(t *testing.T) {
 		{&appFlags{tls: true, tlsCAFile: "acctest/x509/certificate.pem"}, false, true, "only one tls option should be specified"},
 		{&appFlags{tlsInsecure: true, tlsCAFile: "acctest/x509/certificate.pem"}, false, true, "only one tls option should be specified"},
 		{&appFlags{tlsCAFile: "acctest/x509/certificate.pem", tlsCAPath: "acctest/x509"}, false, true, "only one tls option should be specified"},
+		{&appFlags{tls: true, tlsCertFile: "acctest/x509/certificate.pem"}, false, true, "client certificate requires key"},
+		{&appFlags{tls: true, tlsKeyFile: "acctest/key.pem"}, false, true, "client key requires certificate"},
+		{&appFlags{tlsCertFile: "acctest/x509/certificate.pem", tlsKeyFile: "acctest/key.pem"}, false, true, "client certificate requires tls"},
+		{&appFlags{tls: true, tlsCertFile: "acctest/key.pem", tlsKeyFile: "acctest/key.pem"}, false, true, "should fail, acctest/key.pem is not a valid certificate"},
+		{&appFlags{tlsServerName: "app.example.com"}, false, true, "server name requires tls"},
+		{&appFlags{tlsExpectSAN: []string{"app.example.com"}}, false, true, "expected SAN requires tls"},
+		{&appFlags{tlsExpectSPIFFEID: "spiffe://example.org/ns/default/sa/app"}, false, true, "SPIFFE ID requires tls"},
+		{&appFlags{tls: true, tlsExpectSPIFFEID: "example.org/ns/default/sa/app"}, false, true, "SPIFFE ID must have spiffe scheme"},
+		{&appFlags{tlsPins: []string{"sha256/pG92O9tYUKiRIL4Pv1bibXBilZYbQN/1V74aoX8nttI="}}, false, true, "pin requires tls"},
+		{&appFlags{tls: true, tlsPins: []string{"pG92O9tYUKiRIL4Pv1bibXBilZYbQN/1V74aoX8nttI="}}, false, true, "pin must have sha256/ prefix"},
 	}
 
 	for _, tt := range dataset {
@@ -165,6 +362,7 @@ func Test_countTLSFlags(t *testing.T) {
 		{&appFlags{tls: true, tlsInsecure: true}, 2},
 		{&appFlags{tls: true, tlsInsecure: true, tlsCAFile: "file"}, 3},
 		{&appFlags{tls: true, tlsInsecure: true, tlsCAFile: "file", tlsCAPath: "path"}, 4},
+		{&appFlags{tls: true, tlsCertFile: "cert", tlsKeyFile: "key"}, 1},
 	}
 
 	for _, tt := range dataset {
@@ -175,3 +373,35 @@ func Test_countTLSFlags(t *testing.T) {
 		assert.Equal(t, tt.count, cnt)
 	}
 }
+
+func Test_failureExitCode(t *testing.T) {
+	// given
+	dataset := []struct {
+		err      error
+		exitCode int
+	}{
+		{status.Error(codes.Unavailable, `connection error: desc = "transport: Error while dialing: dial tcp: `+
+			`lookup nosuchhost.invalid: no such host"`), ExitCodeResolveFailed},
+		{status.Error(codes.Unavailable, `connection error: desc = "transport: Error while dialing: dial tcp `+
+			`127.0.0.1:1: connect: connection refused"`), ExitCodeConnectionFailed},
+		{status.Error(codes.Unavailable, `connection error: desc = "transport: authentication handshake failed: `+
+			`x509: certificate signed by unknown authority"`), ExitCodeTLSFailed},
+		{status.Error(codes.DeadlineExceeded, "context deadline exceeded"), ExitCodeTimeout},
+		{status.Error(codes.Unimplemented, "unknown service grpc.health.v1.Health"), ExitCodeUnimplemented},
+		{status.Error(codes.NotFound, "unknown service"), ExitCodeServiceUnknown},
+		{status.Error(codes.Unauthenticated, "bearer token is missing"), ExitCodeAuthFailed},
+		{status.Error(codes.PermissionDenied, "invalid bearer token"), ExitCodeAuthFailed},
+		{status.Error(codes.Internal, "boom"), ExitCodeUnexpected},
+		{fmt.Errorf("boom"), ExitCodeUnexpected},
+	}
+
+	for _, tt := range dataset {
+		// when
+		exitCode := failureExitCode(&appConfig{}, tt.err)
+		legacyExitCode := failureExitCode(&appConfig{legacyExitCodes: true}, tt.err)
+
+		// then
+		assert.Equal(t, tt.exitCode, exitCode, tt.err.Error())
+		assert.Equal(t, ExitCodeUnexpected, legacyExitCode, tt.err.Error())
+	}
+}
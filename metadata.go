@@ -0,0 +1,131 @@
+// PUBLIC DOMAIN NOTICE
+// National Center for Biotechnology Information
+//
+// This software/database is a "United States Government Work" under the
+// terms of the United States Copyright Act.  It was written as part of
+// the author's official duties as a United States Government employee and
+// thus cannot be copyrighted.  This software/database is freely available
+// to the public for use. The National Library of Medicine and the U.S.
+// Government have not placed any restriction on its use or reproduction.
+//
+// Although all reasonable efforts have been taken to ensure the accuracy
+// and reliability of the software and data, the NLM and the U.S.
+// Government do not and cannot warrant the performance or results that
+// may be obtained by using this software or data. The NLM and the U.S.
+// Government disclaim all warranties, express or implied, including
+// warranties of performance, merchantability or fitness for any particular
+// purpose.
+//
+// Please cite the author in any work or product based on this material.
+
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"io"
+	"os"
+	"sort"
+	"strings"
+)
+
+// parseHeaders converts key:value pairs into request metadata. Values of binary keys (ending with -bin) are base64
+// encoded
+func parseHeaders(headers []string) (metadata.MD, error) {
+	md := metadata.MD{}
+	for _, header := range headers {
+		parts := strings.SplitN(header, ":", 2)
+		key := strings.ToLower(strings.TrimSpace(parts[0]))
+		if len(parts) != 2 || len(key) == 0 {
+			return nil, fmt.Errorf("header must be in key:value format, got %s", header)
+		}
+		value := strings.TrimSpace(parts[1])
+		if strings.HasSuffix(key, "-bin") {
+			decoded, err := base64.StdEncoding.DecodeString(value)
+			if err != nil {
+				return nil, fmt.Errorf("value of binary header %s must be base64 encoded: %s", key, err.Error())
+			}
+			value = string(decoded)
+		}
+		md.Append(key, value)
+	}
+	return md, nil
+}
+
+// metadataUnaryInterceptor attaches configured metadata to outgoing calls and prints response metadata if asked to
+func metadataUnaryInterceptor(config *appConfig) grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn,
+		invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		if len(config.metadata) > 0 {
+			ctx = metadata.NewOutgoingContext(ctx, config.metadata)
+		}
+		if !config.printHeaders {
+			return invoker(ctx, method, req, reply, cc, opts...)
+		}
+
+		var header, trailer metadata.MD
+		err := invoker(ctx, method, req, reply, cc, append(opts, grpc.Header(&header), grpc.Trailer(&trailer))...)
+		printMetadata(os.Stderr, "header", header)
+		printMetadata(os.Stderr, "trailer", trailer)
+		return err
+	}
+}
+
+// metadataStreamInterceptor attaches configured metadata to outgoing streams and prints response metadata if asked to
+func metadataStreamInterceptor(config *appConfig) grpc.StreamClientInterceptor {
+	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string,
+		streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		if len(config.metadata) > 0 {
+			ctx = metadata.NewOutgoingContext(ctx, config.metadata)
+		}
+		stream, err := streamer(ctx, desc, cc, method, opts...)
+		if err != nil || !config.printHeaders {
+			return stream, err
+		}
+		return &metadataPrintingStream{ClientStream: stream, w: os.Stderr}, nil
+	}
+}
+
+// metadataPrintingStream prints headers once the first message is received and trailers once the stream ends
+type metadataPrintingStream struct {
+	grpc.ClientStream
+	w              io.Writer
+	headerPrinted  bool
+	trailerPrinted bool
+}
+
+func (stream *metadataPrintingStream) RecvMsg(m interface{}) error {
+	err := stream.ClientStream.RecvMsg(m)
+	if !stream.headerPrinted {
+		stream.headerPrinted = true
+		// headers are available once a message is received or the stream ends, so Header doesn't block here
+		header, _ := stream.Header()
+		printMetadata(stream.w, "header", header)
+	}
+	if err != nil && !stream.trailerPrinted {
+		stream.trailerPrinted = true
+		printMetadata(stream.w, "trailer", stream.Trailer())
+	}
+	return err
+}
+
+// printMetadata prints metadata sorted by key, one value per line. Binary values are base64 encoded
+func printMetadata(w io.Writer, kind string, md metadata.MD) {
+	keys := make([]string, 0, len(md))
+	for key := range md {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		for _, value := range md[key] {
+			if strings.HasSuffix(key, "-bin") {
+				value = base64.StdEncoding.EncodeToString([]byte(value))
+			}
+			fmt.Fprintf(w, "%s %s: %s\n", kind, key, value)
+		}
+	}
+}
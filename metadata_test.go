@@ -0,0 +1,110 @@
+// PUBLIC DOMAIN NOTICE
+// National Center for Biotechnology Information
+//
+// This software/database is a "United States Government Work" under the
+// terms of the United States Copyright Act.  It was written as part of
+// the author's official duties as a United States Government employee and
+// thus cannot be copyrighted.  This software/database is freely available
+// to the public for use. The National Library of Medicine and the U.S.
+// Government have not placed any restriction on its use or reproduction.
+//
+// Although all reasonable efforts have been taken to ensure the accuracy
+// and reliability of the software and data, the NLM and the U.S.
+// Government do not and cannot warrant the performance or results that
+// may be obtained by using this software or data. The NLM and the U.S.
+// Government disclaim all warranties, express or implied, including
+// warranties of performance, merchantability or fitness for any particular
+// purpose.
+//
+// Please cite the author in any work or product based on this material.
+
+package main
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+func Test_parseHeaders(t *testing.T) {
+	// when
+	md, err := parseHeaders([]string{"X-Request-Id: 42", "x-tag:a:b", "x-tag:c", "token-bin:AQID"})
+
+	// then
+	assert.NoError(t, err)
+	assert.Equal(t, metadata.MD{
+		"x-request-id": {"42"},
+		"x-tag":        {"a:b", "c"},
+		"token-bin":    {"\x01\x02\x03"},
+	}, md)
+}
+
+func Test_parseHeaders_invalid(t *testing.T) {
+	// given
+	dataset := []string{"x-request-id", ":42", "token-bin:???"}
+
+	for _, header := range dataset {
+		// when
+		_, err := parseHeaders([]string{header})
+
+		// then
+		assert.Error(t, err, header)
+	}
+}
+
+func Test_printMetadata(t *testing.T) {
+	// given
+	md := metadata.MD{
+		"x-tag":     {"a", "b"},
+		"token-bin": {"\x01\x02\x03"},
+	}
+	buf := new(bytes.Buffer)
+
+	// when
+	printMetadata(buf, "trailer", md)
+
+	// then
+	assert.Equal(t, "trailer token-bin: AQID\ntrailer x-tag: a\ntrailer x-tag: b\n", buf.String())
+}
+
+// fakeClientStream returns given number of messages and then io.EOF
+type fakeClientStream struct {
+	grpc.ClientStream
+	messages int
+}
+
+func (stream *fakeClientStream) RecvMsg(m interface{}) error {
+	if stream.messages == 0 {
+		return io.EOF
+	}
+	stream.messages--
+	return nil
+}
+
+func (stream *fakeClientStream) Header() (metadata.MD, error) {
+	return metadata.Pairs("content-type", "application/grpc"), nil
+}
+
+func (stream *fakeClientStream) Trailer() metadata.MD {
+	return metadata.Pairs("x-tag", "a")
+}
+
+func Test_metadataPrintingStream(t *testing.T) {
+	// given
+	buf := new(bytes.Buffer)
+	stream := &metadataPrintingStream{ClientStream: &fakeClientStream{messages: 2}, w: buf}
+
+	// when
+	var errs []error
+	for i := 0; i < 4; i++ {
+		errs = append(errs, stream.RecvMsg(nil))
+	}
+
+	// then
+	assert.Equal(t, []error{nil, nil, io.EOF, io.EOF}, errs)
+	assert.Equal(t, "header content-type: application/grpc\ntrailer x-tag: a\n", buf.String())
+}
@@ -0,0 +1,110 @@
+// PUBLIC DOMAIN NOTICE
+// National Center for Biotechnology Information
+//
+// This software/database is a "United States Government Work" under the
+// terms of the United States Copyright Act.  It was written as part of
+// the author's official duties as a United States Government employee and
+// thus cannot be copyrighted.  This software/database is freely available
+// to the public for use. The National Library of Medicine and the U.S.
+// Government have not placed any restriction on its use or reproduction.
+//
+// Although all reasonable efforts have been taken to ensure the accuracy
+// and reliability of the software and data, the NLM and the U.S.
+// Government do not and cannot warrant the performance or results that
+// may be obtained by using this software or data. The NLM and the U.S.
+// Government disclaim all warranties, express or implied, including
+// warranties of performance, merchantability or fitness for any particular
+// purpose.
+//
+// Please cite the author in any work or product based on this material.
+
+package main
+
+import (
+	"fmt"
+	"github.com/urfave/cli"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const outputNagios = "nagios"
+
+// Nagios plugin exit codes, see https://nagios-plugins.org/doc/guidelines.html#AEN78
+const (
+	nagiosOK       = 0
+	nagiosWarning  = 1
+	nagiosCritical = 2
+	nagiosUnknown  = 3
+)
+
+var nagiosStates = []string{"OK", "WARNING", "CRITICAL", "UNKNOWN"}
+
+// nagiosMain checks service health and reports it as Nagios plugin: prints single line with state, message and
+// performance data, exits with plugin exit code
+func nagiosMain(w io.Writer, config *appConfig) *cli.ExitError {
+	result := probe(config)
+	state, message := nagiosResult(config, result)
+	fmt.Fprintf(w, "%s - %s | %s\n", nagiosStates[state], message, nagiosPerfdata(config, result))
+	return cli.NewExitError("", state)
+}
+
+// nagiosUsageError reports usage error as Nagios plugin does
+func nagiosUsageError(w io.Writer, err error) *cli.ExitError {
+	fmt.Fprintf(w, "%s - %s\n", nagiosStates[nagiosUnknown], err.Error())
+	return cli.NewExitError("", nagiosUnknown)
+}
+
+// nagiosResult maps probe result onto Nagios plugin state. Health status passing the check is OK, failing one is
+// CRITICAL, mapped to warn is WARNING. Failures to get health status are CRITICAL unless gprobe can't tell the cause
+func nagiosResult(config *appConfig, result probeResult) (state int, message string) {
+	target := strings.TrimSpace(config.serverAddress + " " + config.serviceName)
+	if result.err != nil {
+		state = nagiosCritical
+		// legacy exit codes don't matter here, the cause is told by default ones
+		if failureExitCode(&appConfig{}, result.err) == ExitCodeUnexpected {
+			state = nagiosUnknown
+		}
+		return state, fmt.Sprintf("%s: %s", target, toHumanReadable(result.err, config.serviceName))
+	}
+
+	message = fmt.Sprintf("%s is %s", target, result.status.String())
+	switch exitErr := exitError(config, result); exitErr.ExitCode() {
+	case 0:
+		state = nagiosOK
+	case ExitCodeHealthCheckWarning:
+		state = nagiosWarning
+	case ExitCodeCertificateExpiring:
+		state = nagiosWarning
+		message += ", " + exitErr.Error()
+	default:
+		state = nagiosCritical
+	}
+
+	switch {
+	case config.criticalLatency > 0 && result.latency >= config.criticalLatency:
+		state = nagiosCritical
+		message += fmt.Sprintf(", response time %s exceeds %s", result.latency, config.criticalLatency)
+	case config.warningLatency > 0 && result.latency >= config.warningLatency:
+		if state == nagiosOK {
+			state = nagiosWarning
+		}
+		message += fmt.Sprintf(", response time %s exceeds %s", result.latency, config.warningLatency)
+	}
+	return state, message
+}
+
+// nagiosPerfdata formats check latency as performance data: 'label'=value[UOM];[warn];[crit];[min];[max]
+func nagiosPerfdata(config *appConfig, result probeResult) string {
+	return fmt.Sprintf("time=%ss;%s;%s;0;%s", formatSeconds(result.latency), formatSeconds(config.warningLatency),
+		formatSeconds(config.criticalLatency), formatSeconds(config.timeout))
+}
+
+// formatSeconds formats duration in seconds, zero duration is formatted as empty string
+func formatSeconds(d time.Duration) string {
+	if d == 0 {
+		return ""
+	}
+	return strconv.FormatFloat(d.Seconds(), 'f', -1, 64)
+}
@@ -0,0 +1,91 @@
+// PUBLIC DOMAIN NOTICE
+// National Center for Biotechnology Information
+//
+// This software/database is a "United States Government Work" under the
+// terms of the United States Copyright Act.  It was written as part of
+// the author's official duties as a United States Government employee and
+// thus cannot be copyrighted.  This software/database is freely available
+// to the public for use. The National Library of Medicine and the U.S.
+// Government have not placed any restriction on its use or reproduction.
+//
+// Although all reasonable efforts have been taken to ensure the accuracy
+// and reliability of the software and data, the NLM and the U.S.
+// Government do not and cannot warrant the performance or results that
+// may be obtained by using this software or data. The NLM and the U.S.
+// Government disclaim all warranties, express or implied, including
+// warranties of performance, merchantability or fitness for any particular
+// purpose.
+//
+// Please cite the author in any work or product based on this material.
+
+package main
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	hv1 "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+)
+
+func Test_nagiosResult(t *testing.T) {
+	// given
+	warnPolicy, _ := createStatusPolicy(&appFlags{statusMap: "NOT_SERVING=warn"})
+	dataset := []struct {
+		config  *appConfig
+		result  probeResult
+		state   int
+		message string
+	}{
+		{&appConfig{serverAddress: "localhost:1234"},
+			probeResult{status: hv1.HealthCheckResponse_SERVING},
+			nagiosOK, "localhost:1234 is SERVING"},
+		{&appConfig{serverAddress: "localhost:1234", serviceName: "foo"},
+			probeResult{status: hv1.HealthCheckResponse_NOT_SERVING},
+			nagiosCritical, "localhost:1234 foo is NOT_SERVING"},
+		{&appConfig{serverAddress: "localhost:1234", serviceName: "foo", statusPolicy: warnPolicy},
+			probeResult{status: hv1.HealthCheckResponse_NOT_SERVING},
+			nagiosWarning, "localhost:1234 foo is NOT_SERVING"},
+		{&appConfig{serverAddress: "localhost:1234", serviceName: "foo"},
+			probeResult{err: status.Error(codes.NotFound, "unknown service")},
+			nagiosCritical, "localhost:1234 foo: rpc error: unknown service foo"},
+		{&appConfig{serverAddress: "localhost:1234"},
+			probeResult{err: fmt.Errorf("boom")},
+			nagiosUnknown, "localhost:1234: boom"},
+		{&appConfig{serverAddress: "localhost:1234", warningLatency: time.Second, criticalLatency: 2 * time.Second},
+			probeResult{status: hv1.HealthCheckResponse_SERVING, latency: 1500 * time.Millisecond},
+			nagiosWarning, "localhost:1234 is SERVING, response time 1.5s exceeds 1s"},
+		{&appConfig{serverAddress: "localhost:1234", warningLatency: time.Second, criticalLatency: 2 * time.Second},
+			probeResult{status: hv1.HealthCheckResponse_SERVING, latency: 2 * time.Second},
+			nagiosCritical, "localhost:1234 is SERVING, response time 2s exceeds 2s"},
+		{&appConfig{serverAddress: "localhost:1234", warningLatency: time.Second},
+			probeResult{status: hv1.HealthCheckResponse_NOT_SERVING, latency: 1500 * time.Millisecond},
+			nagiosCritical, "localhost:1234 is NOT_SERVING, response time 1.5s exceeds 1s"},
+	}
+
+	for _, tt := range dataset {
+		// when
+		state, message := nagiosResult(tt.config, tt.result)
+
+		// then
+		assert.Equal(t, tt.state, state, tt.message)
+		assert.Equal(t, tt.message, message)
+	}
+}
+
+func Test_nagiosPerfdata(t *testing.T) {
+	// given
+	config := &appConfig{timeout: 10 * time.Second, warningLatency: 500 * time.Millisecond, criticalLatency: time.Second}
+	result := probeResult{latency: 12500 * time.Microsecond}
+
+	// when
+	perfdata := nagiosPerfdata(config, result)
+	noThresholdsPerfdata := nagiosPerfdata(&appConfig{timeout: time.Second}, result)
+
+	// then
+	assert.Equal(t, "time=0.0125s;0.5;1;0;10", perfdata)
+	assert.Equal(t, "time=0.0125s;;;0;1", noThresholdsPerfdata)
+}
@@ -0,0 +1,109 @@
+// PUBLIC DOMAIN NOTICE
+// National Center for Biotechnology Information
+//
+// This software/database is a "United States Government Work" under the
+// terms of the United States Copyright Act.  It was written as part of
+// the author's official duties as a United States Government employee and
+// thus cannot be copyrighted.  This software/database is freely available
+// to the public for use. The National Library of Medicine and the U.S.
+// Government have not placed any restriction on its use or reproduction.
+//
+// Although all reasonable efforts have been taken to ensure the accuracy
+// and reliability of the software and data, the NLM and the U.S.
+// Government do not and cannot warrant the performance or results that
+// may be obtained by using this software or data. The NLM and the U.S.
+// Government disclaim all warranties, express or implied, including
+// warranties of performance, merchantability or fitness for any particular
+// purpose.
+//
+// Please cite the author in any work or product based on this material.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"google.golang.org/grpc/status"
+	"io"
+	"strings"
+	"time"
+)
+
+const (
+	outputText = "text"
+	outputJSON = "json"
+)
+
+// jsonResult is probe result representation printed in json output mode. Dial and RPC time are measured in the last
+// attempt, TLS expiry and validity describe server certificate which expires first
+type jsonResult struct {
+	Target             string   `json:"target"`
+	Service            string   `json:"service"`
+	Status             string   `json:"status,omitempty"`
+	Code               string   `json:"code"`
+	Message            string   `json:"message,omitempty"`
+	LatencySeconds     float64  `json:"latency_seconds"`
+	DialSeconds        float64  `json:"dial_seconds"`
+	RPCSeconds         float64  `json:"rpc_seconds"`
+	Attempts           int      `json:"attempts"`
+	TLS                string   `json:"tls"`
+	TLSExpiry          string   `json:"tls_expiry,omitempty"`
+	TLSValiditySeconds *float64 `json:"tls_validity_seconds,omitempty"`
+	ExitCode           int      `json:"exit_code"`
+}
+
+// printResult prints probe result using configured output format
+func printResult(w io.Writer, config *appConfig, result probeResult, exitCode int) {
+	if config.output == outputJSON {
+		printJSONResult(w, config, result, exitCode)
+		return
+	}
+	if result.err == nil {
+		fmt.Fprintln(w, result.status.String())
+	}
+}
+
+// printTargetResult prints probe result of one of multiple targets. Unlike printResult it prefixes text output with
+// target address and service and includes error message
+func printTargetResult(w io.Writer, config *appConfig, result probeResult, exitCode int) {
+	if config.output == outputJSON {
+		printJSONResult(w, config, result, exitCode)
+		return
+	}
+	message := result.status.String()
+	if result.err != nil {
+		message = toHumanReadable(result.err, config.serviceName).Error()
+	}
+	fields := []string{config.serverAddress, config.serviceName, message}
+	if len(config.serviceName) == 0 {
+		fields = []string{config.serverAddress, message}
+	}
+	fmt.Fprintln(w, strings.Join(fields, " "))
+}
+
+func printJSONResult(w io.Writer, config *appConfig, result probeResult, exitCode int) {
+	rpcStatus := status.Convert(result.err)
+	out := jsonResult{
+		Target:         config.serverAddress,
+		Service:        config.serviceName,
+		Code:           rpcStatus.Code().String(),
+		Message:        rpcStatus.Message(),
+		LatencySeconds: result.latency.Seconds(),
+		DialSeconds:    result.timing.dial().Seconds(),
+		RPCSeconds:     result.timing.rpc.Seconds(),
+		Attempts:       result.attempts,
+		TLS:            config.tlsMode,
+		ExitCode:       exitCode,
+	}
+	if result.err == nil {
+		out.Status = result.status.String()
+	}
+	if result.expiringCert != nil {
+		validity := time.Until(result.expiringCert.NotAfter).Seconds()
+		out.TLSExpiry = result.expiringCert.NotAfter.Format(time.RFC3339)
+		out.TLSValiditySeconds = &validity
+	}
+	// marshalling of plain struct never fails
+	encoded, _ := json.Marshal(out)
+	fmt.Fprintln(w, string(encoded))
+}
@@ -0,0 +1,130 @@
+// PUBLIC DOMAIN NOTICE
+// National Center for Biotechnology Information
+//
+// This software/database is a "United States Government Work" under the
+// terms of the United States Copyright Act.  It was written as part of
+// the author's official duties as a United States Government employee and
+// thus cannot be copyrighted.  This software/database is freely available
+// to the public for use. The National Library of Medicine and the U.S.
+// Government have not placed any restriction on its use or reproduction.
+//
+// Although all reasonable efforts have been taken to ensure the accuracy
+// and reliability of the software and data, the NLM and the U.S.
+// Government do not and cannot warrant the performance or results that
+// may be obtained by using this software or data. The NLM and the U.S.
+// Government disclaim all warranties, express or implied, including
+// warranties of performance, merchantability or fitness for any particular
+// purpose.
+//
+// Please cite the author in any work or product based on this material.
+
+package main
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	hv1 "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+)
+
+func Test_printResult_text(t *testing.T) {
+	// given
+	config := &appConfig{output: outputText}
+	buf := new(bytes.Buffer)
+
+	// when
+	printResult(buf, config, probeResult{status: hv1.HealthCheckResponse_NOT_SERVING}, ExitCodeHealthCheckNegative)
+
+	// then
+	assert.Equal(t, "NOT_SERVING\n", buf.String())
+}
+
+func Test_printResult_text_error(t *testing.T) {
+	// given
+	config := &appConfig{}
+	buf := new(bytes.Buffer)
+
+	// when
+	printResult(buf, config, probeResult{err: fmt.Errorf("boom")}, ExitCodeUnexpected)
+
+	// then
+	assert.Empty(t, buf.String())
+}
+
+func Test_printResult_json(t *testing.T) {
+	// given
+	config := &appConfig{
+		output:        outputJSON,
+		serverAddress: "localhost:1234",
+		serviceName:   "foo",
+		tlsMode:       "insecure",
+	}
+	result := probeResult{
+		status:   hv1.HealthCheckResponse_SERVING,
+		latency:  1500 * time.Millisecond,
+		attempts: 2,
+		timing:   probeTiming{resolve: 100 * time.Millisecond, connect: 400 * time.Millisecond, rpc: 250 * time.Millisecond},
+	}
+	buf := new(bytes.Buffer)
+
+	// when
+	printResult(buf, config, result, 0)
+
+	// then
+	var out map[string]interface{}
+	assert.NoError(t, json.Unmarshal(buf.Bytes(), &out))
+	assert.Equal(t, map[string]interface{}{
+		"target":          "localhost:1234",
+		"service":         "foo",
+		"status":          "SERVING",
+		"code":            "OK",
+		"latency_seconds": 1.5,
+		"dial_seconds":    0.5,
+		"rpc_seconds":     0.25,
+		"attempts":        float64(2),
+		"tls":             "insecure",
+		"exit_code":       float64(0),
+	}, out)
+}
+
+func Test_printResult_json_error(t *testing.T) {
+	// given
+	config := &appConfig{output: outputJSON, serverAddress: "localhost:1234", tlsMode: "none"}
+	result := probeResult{err: status.Error(codes.Unavailable, "connection refused")}
+	buf := new(bytes.Buffer)
+
+	// when
+	printResult(buf, config, result, ExitCodeUnexpected)
+
+	// then
+	var out jsonResult
+	assert.NoError(t, json.Unmarshal(buf.Bytes(), &out))
+	assert.Empty(t, out.Status)
+	assert.Equal(t, "Unavailable", out.Code)
+	assert.Equal(t, "connection refused", out.Message)
+	assert.Equal(t, ExitCodeUnexpected, out.ExitCode)
+}
+
+func Test_printResult_json_tlsExpiry(t *testing.T) {
+	// given
+	config := &appConfig{output: outputJSON, serverAddress: "localhost:1234", tlsMode: "system"}
+	notAfter := time.Now().Add(48 * time.Hour).Truncate(time.Second)
+	result := probeResult{status: hv1.HealthCheckResponse_SERVING, expiringCert: &x509.Certificate{NotAfter: notAfter}}
+	buf := new(bytes.Buffer)
+
+	// when
+	printResult(buf, config, result, 0)
+
+	// then
+	var out jsonResult
+	assert.NoError(t, json.Unmarshal(buf.Bytes(), &out))
+	assert.Equal(t, notAfter.Format(time.RFC3339), out.TLSExpiry)
+	assert.InDelta(t, (48 * time.Hour).Seconds(), *out.TLSValiditySeconds, 60)
+}
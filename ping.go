@@ -0,0 +1,225 @@
+// PUBLIC DOMAIN NOTICE
+// National Center for Biotechnology Information
+//
+// This software/database is a "United States Government Work" under the
+// terms of the United States Copyright Act.  It was written as part of
+// the author's official duties as a United States Government employee and
+// thus cannot be copyrighted.  This software/database is freely available
+// to the public for use. The National Library of Medicine and the U.S.
+// Government have not placed any restriction on its use or reproduction.
+//
+// Although all reasonable efforts have been taken to ensure the accuracy
+// and reliability of the software and data, the NLM and the U.S.
+// Government do not and cannot warrant the performance or results that
+// may be obtained by using this software or data. The NLM and the U.S.
+// Government disclaim all warranties, express or implied, including
+// warranties of performance, merchantability or fitness for any particular
+// purpose.
+//
+// Please cite the author in any work or product based on this material.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/urfave/cli"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+func pingCommand() cli.Command {
+	flags := &appFlags{}
+	return cli.Command{
+		Name:  "ping",
+		Usage: "check health repeatedly over a single connection and print statistics",
+		Description: "Every check result is printed with its latency, summary with success ratio, status histogram " +
+			"and latency percentiles is printed when gprobe is interrupted or --count checks are done",
+		ArgsUsage:    "server_address [service_name]",
+		HideHelp:     true,
+		OnUsageError: onCommandUsageError,
+		Flags: append(connectionFlags(flags),
+			cli.IntFlag{
+				Name:        "count, c",
+				Usage:       "Stop after specified number of checks, 0 checks until interrupted",
+				Destination: &flags.count,
+			},
+			cli.DurationFlag{
+				Name:        "interval, i",
+				Usage:       "Delay between checks",
+				Destination: &flags.interval,
+				Value:       1 * time.Second,
+			},
+			cli.BoolFlag{
+				Name:        "print-peer",
+				Usage:       "Print address of the peer which answered every check",
+				Destination: &flags.printPeer,
+			},
+		),
+		Action: func(c *cli.Context) error {
+			config, err := createConfig(flags, c.Args())
+			if err != nil {
+				return onCommandUsageError(c, err, false)
+			}
+			if config.count < 0 {
+				return onCommandUsageError(c, fmt.Errorf("--count can't be negative, got %d", config.count), false)
+			}
+			if config.interval <= 0 {
+				return onCommandUsageError(c, fmt.Errorf("--interval must be positive"), false)
+			}
+			return pingMain(os.Stdout, config)
+		},
+	}
+}
+
+// pingMain checks health every interval until interrupted or count checks are done, then prints statistics. Exit
+// code is 0 only if all the checks passed
+func pingMain(w io.Writer, config *appConfig) *cli.ExitError {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	cancelOnInterrupt(cancel)
+
+	connection, err := connect(ctx, config)
+	if err != nil {
+		return cli.NewExitError(fmt.Sprintf("can't connect to application: %s", err.Error()), ExitCodeUnexpected)
+	}
+	defer connection.Close()
+
+	stats := &pingStats{statuses: map[string]int{}}
+	ticker := time.NewTicker(config.interval)
+	defer ticker.Stop()
+	for seq := 1; config.count == 0 || seq <= config.count; seq++ {
+		if seq > 1 {
+			select {
+			case <-ctx.Done():
+			case <-ticker.C:
+			}
+		}
+		if ctx.Err() != nil {
+			// interrupted by user
+			break
+		}
+		result, peerAddress := pingOnce(ctx, connection, config)
+		if ctx.Err() != nil {
+			// the check was interrupted, it doesn't count
+			break
+		}
+		passed := exitError(config, result).ExitCode() == 0
+		stats.add(result, passed)
+		printPing(w, config, seq, result, peerAddress)
+	}
+
+	stats.print(w, config.serverAddress)
+	if stats.passed < stats.sent {
+		message := fmt.Sprintf("%d of %d checks failed", stats.sent-stats.passed, stats.sent)
+		return cli.NewExitError(message, ExitCodeHealthCheckNegative)
+	}
+	return cli.NewExitError("", 0)
+}
+
+// pingOnce checks health over given connection and tells which peer answered
+func pingOnce(ctx context.Context, connection *grpc.ClientConn, config *appConfig) (result probeResult,
+	peerAddress string) {
+	ctx, cancel := context.WithTimeout(ctx, config.timeout)
+	defer cancel()
+
+	var server peer.Peer
+	start := time.Now()
+	result.status, result.err = config.statusPolicy.apply(check(ctx, connection, config.serviceName,
+		grpc.Peer(&server)))
+	result.latency = time.Since(start)
+	result.attempts = 1
+	if server.Addr != nil {
+		peerAddress = server.Addr.String()
+	}
+	return
+}
+
+func printPing(w io.Writer, config *appConfig, seq int, result probeResult, peerAddress string) {
+	message := result.status.String()
+	if result.err != nil {
+		message = toHumanReadable(result.err, config.serviceName).Error()
+	}
+	fields := []string{fmt.Sprintf("seq=%d", seq)}
+	if config.printPeer {
+		if len(peerAddress) == 0 {
+			peerAddress = "none"
+		}
+		fields = append(fields, "peer="+peerAddress)
+	}
+	fields = append(fields, message, fmt.Sprintf("time=%s", result.latency))
+	fmt.Fprintf(w, "%s: %s\n", config.serverAddress, strings.Join(fields, " "))
+}
+
+// pingStats accumulates results of repeated checks
+type pingStats struct {
+	sent   int
+	passed int
+	// statuses counts health statuses, failed checks are counted by gRPC code
+	statuses map[string]int
+	// latencies of checks which got response from the server
+	latencies []time.Duration
+}
+
+func (stats *pingStats) add(result probeResult, passed bool) {
+	stats.sent++
+	if passed {
+		stats.passed++
+	}
+	if result.err != nil {
+		stats.statuses[status.Code(result.err).String()]++
+		return
+	}
+	stats.statuses[result.status.String()]++
+	stats.latencies = append(stats.latencies, result.latency)
+}
+
+// percentile returns latency below which given percent of latencies fall using nearest-rank method
+func (stats *pingStats) percentile(percent int) time.Duration {
+	sorted := append([]time.Duration{}, stats.latencies...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	rank := (percent*len(sorted) + 99) / 100
+	if rank < 1 {
+		rank = 1
+	}
+	return sorted[rank-1]
+}
+
+func (stats *pingStats) print(w io.Writer, serverAddress string) {
+	fmt.Fprintf(w, "--- %s ping statistics ---\n", serverAddress)
+	ratio := 0.0
+	if stats.sent > 0 {
+		ratio = float64(stats.passed) / float64(stats.sent) * 100
+	}
+	fmt.Fprintf(w, "%d checks, %d passed, %.1f%% success\n", stats.sent, stats.passed, ratio)
+
+	names := make([]string, 0, len(stats.statuses))
+	for name := range stats.statuses {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	histogram := make([]string, len(names))
+	for i, name := range names {
+		histogram[i] = fmt.Sprintf("%s=%d", name, stats.statuses[name])
+	}
+	if len(histogram) > 0 {
+		fmt.Fprintf(w, "statuses %s\n", strings.Join(histogram, " "))
+	}
+
+	if len(stats.latencies) == 0 {
+		return
+	}
+	var sum time.Duration
+	for _, latency := range stats.latencies {
+		sum += latency
+	}
+	avg := sum / time.Duration(len(stats.latencies))
+	fmt.Fprintf(w, "latency min/avg/p50/p95/p99/max = %s/%s/%s/%s/%s/%s\n", stats.percentile(0), avg,
+		stats.percentile(50), stats.percentile(95), stats.percentile(99), stats.percentile(100))
+}
@@ -0,0 +1,91 @@
+// PUBLIC DOMAIN NOTICE
+// National Center for Biotechnology Information
+//
+// This software/database is a "United States Government Work" under the
+// terms of the United States Copyright Act.  It was written as part of
+// the author's official duties as a United States Government employee and
+// thus cannot be copyrighted.  This software/database is freely available
+// to the public for use. The National Library of Medicine and the U.S.
+// Government have not placed any restriction on its use or reproduction.
+//
+// Although all reasonable efforts have been taken to ensure the accuracy
+// and reliability of the software and data, the NLM and the U.S.
+// Government do not and cannot warrant the performance or results that
+// may be obtained by using this software or data. The NLM and the U.S.
+// Government disclaim all warranties, express or implied, including
+// warranties of performance, merchantability or fitness for any particular
+// purpose.
+//
+// Please cite the author in any work or product based on this material.
+
+package main
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	hv1 "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+)
+
+func Test_pingStats(t *testing.T) {
+	// given
+	stats := &pingStats{statuses: map[string]int{}}
+	for i := 1; i <= 100; i++ {
+		stats.add(probeResult{status: hv1.HealthCheckResponse_SERVING, latency: time.Duration(i) * time.Millisecond}, true)
+	}
+	stats.add(probeResult{status: hv1.HealthCheckResponse_NOT_SERVING, latency: 200 * time.Millisecond}, false)
+	stats.add(probeResult{err: status.Error(codes.Unavailable, "connection refused")}, false)
+	buf := new(bytes.Buffer)
+
+	// when
+	stats.print(buf, "localhost:1234")
+
+	// then
+	assert.Equal(t, "--- localhost:1234 ping statistics ---\n"+
+		"102 checks, 100 passed, 98.0% success\n"+
+		"statuses NOT_SERVING=1 SERVING=100 Unavailable=1\n"+
+		"latency min/avg/p50/p95/p99/max = 1ms/51.980198ms/51ms/96ms/100ms/200ms\n", buf.String())
+}
+
+func Test_pingStats_percentile(t *testing.T) {
+	// given
+	stats := &pingStats{latencies: []time.Duration{5, 1, 3, 2, 4}}
+
+	// then
+	assert.Equal(t, time.Duration(1), stats.percentile(0))
+	assert.Equal(t, time.Duration(3), stats.percentile(50))
+	assert.Equal(t, time.Duration(5), stats.percentile(95))
+	assert.Equal(t, time.Duration(5), stats.percentile(100))
+}
+
+func Test_pingStats_noChecks(t *testing.T) {
+	// given
+	stats := &pingStats{statuses: map[string]int{}}
+	buf := new(bytes.Buffer)
+
+	// when
+	stats.print(buf, "localhost:1234")
+
+	// then
+	assert.Equal(t, "--- localhost:1234 ping statistics ---\n0 checks, 0 passed, 0.0% success\n", buf.String())
+}
+
+func Test_printPing(t *testing.T) {
+	// given
+	config := &appConfig{serverAddress: "localhost:1234", serviceName: "foo", printPeer: true}
+	buf := new(bytes.Buffer)
+
+	// when
+	printPing(buf, config, 7, probeResult{status: hv1.HealthCheckResponse_SERVING, latency: time.Millisecond},
+		"127.0.0.1:1234")
+	printPing(buf, config, 8, probeResult{err: status.Error(codes.NotFound, "unknown service"), latency: time.Millisecond},
+		"")
+
+	// then
+	assert.Equal(t, "localhost:1234: seq=7 peer=127.0.0.1:1234 SERVING time=1ms\n"+
+		"localhost:1234: seq=8 peer=none rpc error: unknown service foo time=1ms\n", buf.String())
+}
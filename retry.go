@@ -0,0 +1,98 @@
+// PUBLIC DOMAIN NOTICE
+// National Center for Biotechnology Information
+//
+// This software/database is a "United States Government Work" under the
+// terms of the United States Copyright Act.  It was written as part of
+// the author's official duties as a United States Government employee and
+// thus cannot be copyrighted.  This software/database is freely available
+// to the public for use. The National Library of Medicine and the U.S.
+// Government have not placed any restriction on its use or reproduction.
+//
+// Although all reasonable efforts have been taken to ensure the accuracy
+// and reliability of the software and data, the NLM and the U.S.
+// Government do not and cannot warrant the performance or results that
+// may be obtained by using this software or data. The NLM and the U.S.
+// Government disclaim all warranties, express or implied, including
+// warranties of performance, merchantability or fitness for any particular
+// purpose.
+//
+// Please cite the author in any work or product based on this material.
+
+package main
+
+import (
+	"fmt"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"math/rand"
+	"strings"
+	"time"
+)
+
+// retryPolicy controls how failed checks are retried
+type retryPolicy struct {
+	retries    int
+	backoff    time.Duration
+	maxBackoff time.Duration
+	codes      map[codes.Code]bool
+}
+
+func createRetryPolicy(flags *appFlags) (policy retryPolicy, err error) {
+	if flags.retries < 0 {
+		return policy, fmt.Errorf("--retries can't be negative, got %d", flags.retries)
+	}
+	policy.codes, err = parseCodes(flags.retryCodes)
+	if err != nil {
+		return policy, err
+	}
+	policy.retries = flags.retries
+	policy.backoff = flags.retryBackoff
+	policy.maxBackoff = flags.retryMaxBackoff
+	return policy, nil
+}
+
+// parseCodes parses comma-separated list of gRPC status codes. Code names are case insensitive, underscores are
+// ignored, so both Unavailable and DEADLINE_EXCEEDED are accepted
+func parseCodes(value string) (map[codes.Code]bool, error) {
+	known := make(map[string]codes.Code)
+	for code := codes.OK; code <= codes.Unauthenticated; code++ {
+		known[strings.ToLower(code.String())] = code
+	}
+
+	parsed := make(map[codes.Code]bool)
+	for _, name := range strings.Split(value, ",") {
+		name = strings.TrimSpace(name)
+		if len(name) == 0 {
+			continue
+		}
+		code, isKnown := known[strings.ToLower(strings.Replace(name, "_", "", -1))]
+		if !isKnown {
+			return nil, fmt.Errorf("unknown gRPC status code %s", name)
+		}
+		parsed[code] = true
+	}
+	return parsed, nil
+}
+
+// isRetryable tells if the check failed with a transient error and is worth another attempt
+func (policy retryPolicy) isRetryable(err error) bool {
+	rpcStatus, isRPCError := status.FromError(err)
+	return isRPCError && policy.codes[rpcStatus.Code()]
+}
+
+// nextBackoff doubles the backoff up to the maximum
+func (policy retryPolicy) nextBackoff(backoff time.Duration) time.Duration {
+	backoff *= 2
+	if backoff > policy.maxBackoff {
+		return policy.maxBackoff
+	}
+	return backoff
+}
+
+// jitter randomizes delay within [delay/2, delay] so that concurrent clients don't retry in lockstep
+func jitter(delay time.Duration) time.Duration {
+	if delay <= 0 {
+		return 0
+	}
+	return delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+}
@@ -0,0 +1,96 @@
+// PUBLIC DOMAIN NOTICE
+// National Center for Biotechnology Information
+//
+// This software/database is a "United States Government Work" under the
+// terms of the United States Copyright Act.  It was written as part of
+// the author's official duties as a United States Government employee and
+// thus cannot be copyrighted.  This software/database is freely available
+// to the public for use. The National Library of Medicine and the U.S.
+// Government have not placed any restriction on its use or reproduction.
+//
+// Although all reasonable efforts have been taken to ensure the accuracy
+// and reliability of the software and data, the NLM and the U.S.
+// Government do not and cannot warrant the performance or results that
+// may be obtained by using this software or data. The NLM and the U.S.
+// Government disclaim all warranties, express or implied, including
+// warranties of performance, merchantability or fitness for any particular
+// purpose.
+//
+// Please cite the author in any work or product based on this material.
+
+package main
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func Test_parseCodes(t *testing.T) {
+	// given
+	dataset := []struct {
+		value         string
+		parsed        map[codes.Code]bool
+		errorReturned bool
+	}{
+		{"", map[codes.Code]bool{}, false},
+		{"Unavailable", map[codes.Code]bool{codes.Unavailable: true}, false},
+		{"unavailable, DEADLINE_EXCEEDED", map[codes.Code]bool{codes.Unavailable: true, codes.DeadlineExceeded: true}, false},
+		{"Unavailable,Foo", nil, true},
+	}
+
+	for _, tt := range dataset {
+		// when
+		parsed, err := parseCodes(tt.value)
+
+		// then
+		assert.Equal(t, tt.parsed, parsed, tt.value)
+		if tt.errorReturned {
+			assert.Error(t, err, tt.value)
+		} else {
+			assert.NoError(t, err, tt.value)
+		}
+	}
+}
+
+func Test_createRetryPolicy_negativeRetries(t *testing.T) {
+	// when
+	_, err := createRetryPolicy(&appFlags{retries: -1})
+
+	// then
+	assert.Error(t, err)
+}
+
+func Test_retryPolicy_isRetryable(t *testing.T) {
+	// given
+	policy := retryPolicy{codes: map[codes.Code]bool{codes.Unavailable: true}}
+
+	// then
+	assert.True(t, policy.isRetryable(status.Error(codes.Unavailable, "")))
+	assert.False(t, policy.isRetryable(status.Error(codes.NotFound, "")))
+	assert.False(t, policy.isRetryable(fmt.Errorf("not an rpc error")))
+}
+
+func Test_retryPolicy_nextBackoff(t *testing.T) {
+	// given
+	policy := retryPolicy{maxBackoff: 300 * time.Millisecond}
+
+	// then
+	assert.Equal(t, 200*time.Millisecond, policy.nextBackoff(100*time.Millisecond))
+	assert.Equal(t, 300*time.Millisecond, policy.nextBackoff(200*time.Millisecond))
+}
+
+func Test_jitter(t *testing.T) {
+	for i := 0; i < 100; i++ {
+		// when
+		delay := jitter(100 * time.Millisecond)
+
+		// then
+		assert.True(t, delay >= 50*time.Millisecond && delay <= 100*time.Millisecond, delay.String())
+	}
+	assert.Equal(t, time.Duration(0), jitter(0))
+}
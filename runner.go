@@ -0,0 +1,288 @@
+// PUBLIC DOMAIN NOTICE
+// National Center for Biotechnology Information
+//
+// This software/database is a "United States Government Work" under the
+// terms of the United States Copyright Act.  It was written as part of
+// the author's official duties as a United States Government employee and
+// thus cannot be copyrighted.  This software/database is freely available
+// to the public for use. The National Library of Medicine and the U.S.
+// Government have not placed any restriction on its use or reproduction.
+//
+// Although all reasonable efforts have been taken to ensure the accuracy
+// and reliability of the software and data, the NLM and the U.S.
+// Government do not and cannot warrant the performance or results that
+// may be obtained by using this software or data. The NLM and the U.S.
+// Government disclaim all warranties, express or implied, including
+// warranties of performance, merchantability or fitness for any particular
+// purpose.
+//
+// Please cite the author in any work or product based on this material.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/urfave/cli"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+// states reported by runner
+const (
+	stateUnknown   = "unknown"
+	stateHealthy   = "healthy"
+	stateUnhealthy = "unhealthy"
+)
+
+// schedule holds kubelet-style probing parameters
+type schedule struct {
+	// initialDelay is delay before the first check
+	initialDelay time.Duration
+	// period is delay between checks
+	period time.Duration
+	// successThreshold is number of consecutive passed checks which make the target healthy
+	successThreshold int
+	// failureThreshold is number of consecutive failed checks which make the target unhealthy
+	failureThreshold int
+}
+
+func createSchedule(flags *appFlags) (schedule, error) {
+	if flags.initialDelay < 0 {
+		return schedule{}, fmt.Errorf("--initial-delay can't be negative")
+	}
+	if flags.period <= 0 {
+		return schedule{}, fmt.Errorf("--period must be positive")
+	}
+	if flags.successThreshold < 1 || flags.failureThreshold < 1 {
+		return schedule{}, fmt.Errorf("--success-threshold and --failure-threshold must be at least 1")
+	}
+	return schedule{
+		initialDelay:     flags.initialDelay,
+		period:           flags.period,
+		successThreshold: flags.successThreshold,
+		failureThreshold: flags.failureThreshold,
+	}, nil
+}
+
+// scheduleFlags are flags of commands checking health by schedule
+func scheduleFlags(flags *appFlags) []cli.Flag {
+	return []cli.Flag{
+		cli.DurationFlag{
+			Name:        "initial-delay",
+			Usage:       "Delay before the first check",
+			Destination: &flags.initialDelay,
+		},
+		cli.DurationFlag{
+			Name:        "period",
+			Usage:       "Delay between checks",
+			Destination: &flags.period,
+			Value:       10 * time.Second,
+		},
+		cli.IntFlag{
+			Name:        "success-threshold",
+			Usage:       "Consecutive passed checks required to consider the target healthy",
+			Destination: &flags.successThreshold,
+			Value:       1,
+		},
+		cli.IntFlag{
+			Name:        "failure-threshold",
+			Usage:       "Consecutive failed checks required to consider the target unhealthy",
+			Destination: &flags.failureThreshold,
+			Value:       3,
+		},
+	}
+}
+
+// clock abstracts time for runner, it is replaced in tests
+type clock interface {
+	Now() time.Time
+	After(d time.Duration) <-chan time.Time
+}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+func (systemClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
+
+// transition is change of target state
+type transition struct {
+	time     time.Time
+	previous string
+	current  string
+	// count is number of consecutive results which caused the transition
+	count  int
+	result probeResult
+	// previousResult is the result which caused the previous transition, zero if there was none
+	previousResult probeResult
+}
+
+// runner checks health by schedule and reports state transitions. The state flips only after threshold number of
+// consecutive results, it is unknown until either threshold is reached for the first time
+type runner struct {
+	config       *appConfig
+	clock        clock
+	probe        func(ctx context.Context) probeResult
+	onTransition func(transition)
+	state        string
+	// stateResult is the result which caused the last transition
+	stateResult probeResult
+	successes   int
+	failures    int
+}
+
+func newRunner(config *appConfig, probe func(ctx context.Context) probeResult,
+	onTransition func(transition)) *runner {
+	return &runner{
+		config:       config,
+		clock:        systemClock{},
+		probe:        probe,
+		onTransition: onTransition,
+		state:        stateUnknown,
+	}
+}
+
+// run checks health after initial delay and then every period until ctx is done
+func (r *runner) run(ctx context.Context) {
+	delay := r.config.schedule.initialDelay
+	for {
+		select {
+		case <-ctx.Done():
+		case <-r.clock.After(delay):
+		}
+		if ctx.Err() != nil {
+			return
+		}
+		result := r.probe(ctx)
+		if ctx.Err() != nil {
+			// the check was interrupted, it doesn't count
+			return
+		}
+		r.record(result)
+		delay = r.config.schedule.period
+	}
+}
+
+// record counts check result and reports transition if threshold is reached
+func (r *runner) record(result probeResult) {
+	current, count := r.state, 0
+	if exitError(r.config, result).ExitCode() == 0 {
+		r.successes, r.failures = r.successes+1, 0
+		if r.successes >= r.config.schedule.successThreshold {
+			current, count = stateHealthy, r.successes
+		}
+	} else {
+		r.successes, r.failures = 0, r.failures+1
+		if r.failures >= r.config.schedule.failureThreshold {
+			current, count = stateUnhealthy, r.failures
+		}
+	}
+	if current == r.state {
+		return
+	}
+
+	event := transition{
+		time:           r.clock.Now(),
+		previous:       r.state,
+		current:        current,
+		count:          count,
+		result:         result,
+		previousResult: r.stateResult,
+	}
+	r.state, r.stateResult = current, result
+	if r.onTransition != nil {
+		r.onTransition(event)
+	}
+}
+
+func monitorCommand() cli.Command {
+	flags := &appFlags{}
+	return cli.Command{
+		Name:  "monitor",
+		Usage: "check health periodically and print state transitions using kubelet probe semantics",
+		Description: "The target becomes healthy after --success-threshold consecutive passed checks and unhealthy " +
+			"after --failure-threshold consecutive failed ones, the state is unknown until either threshold is " +
+			"reached. Every transition is printed out",
+		ArgsUsage:    "server_address [service_name]",
+		HideHelp:     true,
+		OnUsageError: onCommandUsageError,
+		Flags:        append(connectionFlags(flags), scheduleFlags(flags)...),
+		Action: func(c *cli.Context) error {
+			config, err := createConfig(flags, c.Args())
+			if err != nil {
+				return onCommandUsageError(c, err, false)
+			}
+			config.schedule, err = createSchedule(flags)
+			if err != nil {
+				return onCommandUsageError(c, err, false)
+			}
+			return monitorMain(os.Stdout, config)
+		},
+	}
+}
+
+// monitorMain checks health by schedule over a single connection printing state transitions until interrupted
+func monitorMain(w io.Writer, config *appConfig) *cli.ExitError {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	cancelOnInterrupt(cancel)
+
+	connection, err := connect(ctx, config)
+	if err != nil {
+		return cli.NewExitError(fmt.Sprintf("can't connect to application: %s", err.Error()), ExitCodeUnexpected)
+	}
+	defer connection.Close()
+
+	probe := func(ctx context.Context) probeResult {
+		result, _ := pingOnce(ctx, connection, config)
+		return result
+	}
+	newRunner(config, probe, func(event transition) {
+		printTransition(w, config, event)
+	}).run(ctx)
+	return cli.NewExitError("", 0)
+}
+
+// printTransition prints state transition prefixed with target address and service, e.g.
+// 2018-01-30T12:00:00Z localhost:1234 foo healthy -> unhealthy after 3 failures: NOT_SERVING
+func printTransition(w io.Writer, config *appConfig, event transition) {
+	message := event.result.status.String()
+	if event.result.err != nil {
+		message = toHumanReadable(event.result.err, config.serviceName).Error()
+	}
+	results := map[bool]string{true: "success", false: "successes"}
+	if event.current == stateUnhealthy {
+		results = map[bool]string{true: "failure", false: "failures"}
+	}
+	change := fmt.Sprintf("%s -> %s after %d %s: %s", event.previous, event.current, event.count,
+		results[event.count == 1], message)
+	fields := []string{event.time.Format(time.RFC3339), config.serverAddress, config.serviceName, change}
+	if len(config.serviceName) == 0 {
+		fields = append(fields[:2], fields[3])
+	}
+	fmt.Fprintln(w, strings.Join(fields, " "))
+}
+
+// transitionEvent converts state transition into webhook event
+func transitionEvent(config *appConfig, t transition) healthEvent {
+	e := healthEvent{
+		Target:        config.serverAddress,
+		Service:       config.serviceName,
+		Previous:      t.previousResult.status.String(),
+		Current:       t.result.status.String(),
+		PreviousState: t.previous,
+		State:         t.current,
+		Timestamp:     t.time,
+	}
+	if t.result.err != nil {
+		e.Error = toHumanReadable(t.result.err, config.serviceName).Error()
+	}
+	return e
+}
@@ -0,0 +1,172 @@
+// PUBLIC DOMAIN NOTICE
+// National Center for Biotechnology Information
+//
+// This software/database is a "United States Government Work" under the
+// terms of the United States Copyright Act.  It was written as part of
+// the author's official duties as a United States Government employee and
+// thus cannot be copyrighted.  This software/database is freely available
+// to the public for use. The National Library of Medicine and the U.S.
+// Government have not placed any restriction on its use or reproduction.
+//
+// Although all reasonable efforts have been taken to ensure the accuracy
+// and reliability of the software and data, the NLM and the U.S.
+// Government do not and cannot warrant the performance or results that
+// may be obtained by using this software or data. The NLM and the U.S.
+// Government disclaim all warranties, express or implied, including
+// warranties of performance, merchantability or fitness for any particular
+// purpose.
+//
+// Please cite the author in any work or product based on this material.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	hv1 "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+)
+
+// fakeClock advances time instantly whenever runner waits
+type fakeClock struct {
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	return c.now
+}
+
+func (c *fakeClock) After(d time.Duration) <-chan time.Time {
+	c.now = c.now.Add(d)
+	ch := make(chan time.Time, 1)
+	ch <- c.now
+	return ch
+}
+
+var (
+	epoch      = time.Date(2018, 1, 30, 12, 0, 0, 0, time.UTC)
+	serving    = probeResult{status: hv1.HealthCheckResponse_SERVING}
+	notServing = probeResult{status: hv1.HealthCheckResponse_NOT_SERVING}
+	refused    = probeResult{err: status.Error(codes.Unavailable, "connection refused")}
+)
+
+// runScript runs runner over given check results and returns times of checks and reported transitions
+func runScript(s schedule, results ...probeResult) (checks []time.Time, transitions []transition) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	clock := &fakeClock{now: epoch}
+	probe := func(ctx context.Context) probeResult {
+		if len(checks) == len(results) {
+			// script is over, stop the runner
+			cancel()
+			return probeResult{}
+		}
+		checks = append(checks, clock.Now())
+		return results[len(checks)-1]
+	}
+	r := newRunner(&appConfig{schedule: s}, probe, func(event transition) {
+		transitions = append(transitions, event)
+	})
+	r.clock = clock
+	r.run(ctx)
+	return
+}
+
+func Test_runner_schedule(t *testing.T) {
+	// given
+	s := schedule{initialDelay: 30 * time.Second, period: 10 * time.Second, successThreshold: 1, failureThreshold: 1}
+
+	// when
+	checks, _ := runScript(s, serving, serving, serving)
+
+	// then
+	assert.Equal(t, []time.Time{epoch.Add(30 * time.Second), epoch.Add(40 * time.Second), epoch.Add(50 * time.Second)},
+		checks)
+}
+
+func Test_runner_failureThreshold(t *testing.T) {
+	// given
+	s := schedule{period: time.Second, successThreshold: 1, failureThreshold: 3}
+
+	// when
+	_, transitions := runScript(s, serving, notServing, notServing, serving, notServing, refused, notServing)
+
+	// then
+	assert.Equal(t, []transition{
+		{time: epoch, previous: stateUnknown, current: stateHealthy, count: 1, result: serving},
+		{time: epoch.Add(6 * time.Second), previous: stateHealthy, current: stateUnhealthy, count: 3, result: notServing,
+			previousResult: serving},
+	}, transitions)
+}
+
+func Test_runner_successThreshold(t *testing.T) {
+	// given
+	s := schedule{period: time.Second, successThreshold: 2, failureThreshold: 1}
+
+	// when
+	_, transitions := runScript(s, refused, serving, refused, serving, serving, serving)
+
+	// then
+	assert.Equal(t, []transition{
+		{time: epoch, previous: stateUnknown, current: stateUnhealthy, count: 1, result: refused},
+		{time: epoch.Add(4 * time.Second), previous: stateUnhealthy, current: stateHealthy, count: 2, result: serving,
+			previousResult: refused},
+	}, transitions)
+}
+
+func Test_runner_stateIsUnknownUntilThresholdIsReached(t *testing.T) {
+	// given
+	s := schedule{period: time.Second, successThreshold: 3, failureThreshold: 3}
+
+	// when
+	_, transitions := runScript(s, serving, serving, refused, refused, serving)
+
+	// then
+	assert.Empty(t, transitions)
+}
+
+func Test_createSchedule(t *testing.T) {
+	// given
+	flags := &appFlags{initialDelay: time.Minute, period: time.Second, successThreshold: 2, failureThreshold: 5}
+
+	// when
+	s, err := createSchedule(flags)
+
+	// then
+	assert.NoError(t, err)
+	assert.Equal(t, schedule{initialDelay: time.Minute, period: time.Second, successThreshold: 2, failureThreshold: 5}, s)
+}
+
+func Test_createSchedule_invalidThreshold(t *testing.T) {
+	// given
+	flags := &appFlags{period: time.Second, successThreshold: 0, failureThreshold: 5}
+
+	// when
+	_, err := createSchedule(flags)
+
+	// then
+	assert.EqualError(t, err, "--success-threshold and --failure-threshold must be at least 1")
+}
+
+func Test_printTransition(t *testing.T) {
+	// given
+	config := &appConfig{serverAddress: "localhost:1234", serviceName: "foo"}
+	buf := new(bytes.Buffer)
+
+	// when
+	printTransition(buf, config, transition{time: epoch, previous: stateUnknown, current: stateHealthy, count: 1,
+		result: serving})
+	printTransition(buf, config, transition{time: epoch, previous: stateHealthy, current: stateUnhealthy, count: 3,
+		result: refused})
+
+	// then
+	assert.Equal(t, "2018-01-30T12:00:00Z localhost:1234 foo unknown -> healthy after 1 success: SERVING\n"+
+		"2018-01-30T12:00:00Z localhost:1234 foo healthy -> unhealthy after 3 failures: "+
+		"connection refused: application isn't listening or TLS handshake failed\n",
+		buf.String())
+}
@@ -0,0 +1,137 @@
+// PUBLIC DOMAIN NOTICE
+// National Center for Biotechnology Information
+//
+// This software/database is a "United States Government Work" under the
+// terms of the United States Copyright Act.  It was written as part of
+// the author's official duties as a United States Government employee and
+// thus cannot be copyrighted.  This software/database is freely available
+// to the public for use. The National Library of Medicine and the U.S.
+// Government have not placed any restriction on its use or reproduction.
+//
+// Although all reasonable efforts have been taken to ensure the accuracy
+// and reliability of the software and data, the NLM and the U.S.
+// Government do not and cannot warrant the performance or results that
+// may be obtained by using this software or data. The NLM and the U.S.
+// Government disclaim all warranties, express or implied, including
+// warranties of performance, merchantability or fitness for any particular
+// purpose.
+//
+// Please cite the author in any work or product based on this material.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/urfave/cli"
+	"google.golang.org/grpc"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// healthPathPrefix is URL path prefix of health endpoints, service name follows the prefix
+const healthPathPrefix = "/healthz"
+
+// timeouts of HTTP server, they protect long-running health endpoint from clients holding connections open. Writing
+// the response is allowed to take --timeout more since the check happens while the request is being served
+const (
+	httpReadHeaderTimeout = 5 * time.Second
+	httpReadTimeout       = 10 * time.Second
+	httpWriteTimeout      = 10 * time.Second
+	httpIdleTimeout       = 60 * time.Second
+)
+
+func serveHTTPCommand() cli.Command {
+	flags := &appFlags{}
+	return cli.Command{
+		Name:  "serve-http",
+		Usage: "serve health of gRPC application over HTTP",
+		Description: "Every request to " + healthPathPrefix + "/<service_name> checks service health, " +
+			healthPathPrefix + " checks server health. Status 200 is returned if service is SERVING, 503 otherwise, " +
+			"response body holds check result in JSON",
+		ArgsUsage:    "server_address",
+		HideHelp:     true,
+		OnUsageError: onCommandUsageError,
+		Flags: append(connectionFlags(flags),
+			cli.StringFlag{
+				Name:        "listen, l",
+				Usage:       "HTTP listen address",
+				Destination: &flags.listenAddress,
+				Value:       ":8080",
+			},
+		),
+		Action: func(c *cli.Context) error {
+			if len(c.Args()) != 1 {
+				return onCommandUsageError(c, fmt.Errorf("exactly 1 argument is required"), false)
+			}
+			config, err := createConfig(flags, c.Args())
+			if err != nil {
+				return onCommandUsageError(c, err, false)
+			}
+			return serveHTTPMain(config)
+		},
+	}
+}
+
+func serveHTTPMain(config *appConfig) *cli.ExitError {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	cancelOnInterrupt(cancel)
+
+	// connection is shared by all requests, it is re-established by gRPC if broken
+	connection, err := connect(ctx, config)
+	if err != nil {
+		return cli.NewExitError(fmt.Sprintf("can't connect to application: %s", err.Error()), ExitCodeUnexpected)
+	}
+	defer connection.Close()
+
+	server := &http.Server{
+		Addr:              config.listenAddress,
+		Handler:           healthHandler(connection, config),
+		ReadHeaderTimeout: httpReadHeaderTimeout,
+		ReadTimeout:       httpReadTimeout,
+		WriteTimeout:      httpWriteTimeout + config.timeout,
+		IdleTimeout:       httpIdleTimeout,
+	}
+	go func() {
+		<-ctx.Done()
+		server.Shutdown(context.Background())
+	}()
+
+	fmt.Fprintf(os.Stderr, "serving health of %s on %s\n", config.serverAddress, config.listenAddress)
+	err = server.ListenAndServe()
+	if err != http.ErrServerClosed {
+		return cli.NewExitError(fmt.Sprintf("can't serve HTTP: %s", err.Error()), ExitCodeUnexpected)
+	}
+	return cli.NewExitError("", 0)
+}
+
+// healthHandler checks health of the service named in request path using given connection
+func healthHandler(connection *grpc.ClientConn, config *appConfig) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != healthPathPrefix && !strings.HasPrefix(r.URL.Path, healthPathPrefix+"/") {
+			http.NotFound(w, r)
+			return
+		}
+		serviceConfig := *config
+		serviceConfig.serviceName = strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, healthPathPrefix), "/")
+
+		ctx, cancel := context.WithTimeout(r.Context(), config.timeout)
+		defer cancel()
+		start := time.Now()
+		result := probeResult{attempts: 1}
+		result.status, result.err = check(ctx, connection, serviceConfig.serviceName)
+		result.latency = time.Since(start)
+		exitCode := exitError(&serviceConfig, result).ExitCode()
+
+		w.Header().Set("Content-Type", "application/json")
+		if exitCode == 0 {
+			w.WriteHeader(http.StatusOK)
+		} else {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		printJSONResult(w, &serviceConfig, result, exitCode)
+	})
+}
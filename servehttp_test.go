@@ -0,0 +1,120 @@
+// PUBLIC DOMAIN NOTICE
+// National Center for Biotechnology Information
+//
+// This software/database is a "United States Government Work" under the
+// terms of the United States Copyright Act.  It was written as part of
+// the author's official duties as a United States Government employee and
+// thus cannot be copyrighted.  This software/database is freely available
+// to the public for use. The National Library of Medicine and the U.S.
+// Government have not placed any restriction on its use or reproduction.
+//
+// Although all reasonable efforts have been taken to ensure the accuracy
+// and reliability of the software and data, the NLM and the U.S.
+// Government do not and cannot warrant the performance or results that
+// may be obtained by using this software or data. The NLM and the U.S.
+// Government disclaim all warranties, express or implied, including
+// warranties of performance, merchantability or fitness for any particular
+// purpose.
+//
+// Please cite the author in any work or product based on this material.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
+	hv1 "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+// startHealthServer starts in-process gRPC server with health service and returns its address
+func startHealthServer(t *testing.T) (*grpc.Server, *health.Server, string) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := grpc.NewServer()
+	service := health.NewServer()
+	hv1.RegisterHealthServer(server, service)
+	go server.Serve(listener)
+	return server, service, listener.Addr().String()
+}
+
+func Test_healthHandler(t *testing.T) {
+	// given
+	server, service, address := startHealthServer(t)
+	defer server.Stop()
+	service.SetServingStatus("foo", hv1.HealthCheckResponse_SERVING)
+	service.SetServingStatus("bar", hv1.HealthCheckResponse_NOT_SERVING)
+	config := &appConfig{serverAddress: address, timeout: time.Second, tlsMode: "none"}
+	connection, err := connect(context.Background(), config)
+	assert.NoError(t, err)
+	defer connection.Close()
+	handler := healthHandler(connection, config)
+
+	dataset := []struct {
+		path       string
+		statusCode int
+		status     string
+		code       string
+	}{
+		{"/healthz", http.StatusOK, "SERVING", "OK"},
+		{"/healthz/foo", http.StatusOK, "SERVING", "OK"},
+		{"/healthz/bar", http.StatusServiceUnavailable, "NOT_SERVING", "OK"},
+		{"/healthz/baz", http.StatusServiceUnavailable, "", "NotFound"},
+	}
+
+	for _, tt := range dataset {
+		// when
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, tt.path, nil))
+
+		// then
+		assert.Equal(t, tt.statusCode, recorder.Code, tt.path)
+		assert.Equal(t, "application/json", recorder.Header().Get("Content-Type"), tt.path)
+		result := jsonResult{}
+		assert.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &result), tt.path)
+		assert.Equal(t, tt.status, result.Status, tt.path)
+		assert.Equal(t, tt.code, result.Code, tt.path)
+	}
+}
+
+func Test_healthHandler_unavailable(t *testing.T) {
+	// given
+	server, _, address := startHealthServer(t)
+	server.Stop()
+	config := &appConfig{serverAddress: address, timeout: time.Second, tlsMode: "none"}
+	connection, err := connect(context.Background(), config)
+	assert.NoError(t, err)
+	defer connection.Close()
+
+	// when
+	recorder := httptest.NewRecorder()
+	healthHandler(connection, config).ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/healthz/foo", nil))
+
+	// then
+	assert.Equal(t, http.StatusServiceUnavailable, recorder.Code)
+	result := jsonResult{}
+	assert.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &result))
+	assert.Equal(t, "Unavailable", result.Code)
+	assert.Equal(t, ExitCodeConnectionFailed, result.ExitCode)
+}
+
+func Test_healthHandler_unknownPath(t *testing.T) {
+	// given
+	recorder := httptest.NewRecorder()
+
+	// when
+	healthHandler(nil, &appConfig{}).ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+
+	// then
+	assert.Equal(t, http.StatusNotFound, recorder.Code)
+}
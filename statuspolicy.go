@@ -0,0 +1,120 @@
+// PUBLIC DOMAIN NOTICE
+// National Center for Biotechnology Information
+//
+// This software/database is a "United States Government Work" under the
+// terms of the United States Copyright Act.  It was written as part of
+// the author's official duties as a United States Government employee and
+// thus cannot be copyrighted.  This software/database is freely available
+// to the public for use. The National Library of Medicine and the U.S.
+// Government have not placed any restriction on its use or reproduction.
+//
+// Although all reasonable efforts have been taken to ensure the accuracy
+// and reliability of the software and data, the NLM and the U.S.
+// Government do not and cannot warrant the performance or results that
+// may be obtained by using this software or data. The NLM and the U.S.
+// Government disclaim all warranties, express or implied, including
+// warranties of performance, merchantability or fitness for any particular
+// purpose.
+//
+// Please cite the author in any work or product based on this material.
+
+package main
+
+import (
+	"fmt"
+	"google.golang.org/grpc/codes"
+	hv1 "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+	"strings"
+)
+
+// outcome of health status
+const (
+	outcomeOK   = "ok"
+	outcomeWarn = "warn"
+	outcomeFail = "fail"
+)
+
+// statusPolicy decides whether health status passes the check
+type statusPolicy struct {
+	// outcomes of statuses, SERVING passes and any other status fails if not set
+	outcomes map[hv1.HealthCheckResponse_ServingStatus]string
+	// notFoundAsUnknown makes NotFound error reported as SERVICE_UNKNOWN status
+	notFoundAsUnknown bool
+}
+
+func createStatusPolicy(flags *appFlags) (policy statusPolicy, err error) {
+	policy.notFoundAsUnknown = flags.notFoundAsUnknown
+	if len(flags.expect) == 0 && len(flags.statusMap) == 0 {
+		return policy, nil
+	}
+
+	policy.outcomes = map[hv1.HealthCheckResponse_ServingStatus]string{}
+	for value := range hv1.HealthCheckResponse_ServingStatus_name {
+		servingStatus := hv1.HealthCheckResponse_ServingStatus(value)
+		policy.outcomes[servingStatus] = policy.outcome(servingStatus)
+	}
+	if len(flags.expect) > 0 {
+		for servingStatus := range policy.outcomes {
+			policy.outcomes[servingStatus] = outcomeFail
+		}
+		for _, name := range flags.expect {
+			servingStatus, err := parseServingStatus(name)
+			if err != nil {
+				return policy, err
+			}
+			policy.outcomes[servingStatus] = outcomeOK
+		}
+	}
+
+	for _, entry := range strings.Split(flags.statusMap, ",") {
+		entry = strings.TrimSpace(entry)
+		if len(entry) == 0 {
+			continue
+		}
+		parts := strings.SplitN(entry, "=", 2)
+		if len(parts) != 2 {
+			return policy, fmt.Errorf("invalid --map entry %s, expected STATUS=ok|warn|fail", entry)
+		}
+		servingStatus, err := parseServingStatus(parts[0])
+		if err != nil {
+			return policy, err
+		}
+		switch outcome := strings.ToLower(strings.TrimSpace(parts[1])); outcome {
+		case outcomeOK, outcomeWarn, outcomeFail:
+			policy.outcomes[servingStatus] = outcome
+		default:
+			return policy, fmt.Errorf("invalid --map entry %s, expected STATUS=ok|warn|fail", entry)
+		}
+	}
+	return policy, nil
+}
+
+// parseServingStatus parses health status name, e.g. NOT_SERVING. Names are case insensitive
+func parseServingStatus(name string) (hv1.HealthCheckResponse_ServingStatus, error) {
+	value, isKnown := hv1.HealthCheckResponse_ServingStatus_value[strings.ToUpper(strings.TrimSpace(name))]
+	if !isKnown {
+		return hv1.HealthCheckResponse_UNKNOWN, fmt.Errorf("unknown health status %s", name)
+	}
+	return hv1.HealthCheckResponse_ServingStatus(value), nil
+}
+
+// outcome tells if health status passes the check (ok), passes with a warning (warn) or fails (fail)
+func (policy statusPolicy) outcome(servingStatus hv1.HealthCheckResponse_ServingStatus) string {
+	if outcome, isSet := policy.outcomes[servingStatus]; isSet {
+		return outcome
+	}
+	if servingStatus == hv1.HealthCheckResponse_SERVING {
+		return outcomeOK
+	}
+	return outcomeFail
+}
+
+// apply converts NotFound error into SERVICE_UNKNOWN status if configured, otherwise returns check result as is
+func (policy statusPolicy) apply(servingStatus hv1.HealthCheckResponse_ServingStatus, err error) (
+	hv1.HealthCheckResponse_ServingStatus, error) {
+	if policy.notFoundAsUnknown && status.Code(err) == codes.NotFound {
+		return hv1.HealthCheckResponse_SERVICE_UNKNOWN, nil
+	}
+	return servingStatus, err
+}
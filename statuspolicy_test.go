@@ -0,0 +1,115 @@
+// PUBLIC DOMAIN NOTICE
+// National Center for Biotechnology Information
+//
+// This software/database is a "United States Government Work" under the
+// terms of the United States Copyright Act.  It was written as part of
+// the author's official duties as a United States Government employee and
+// thus cannot be copyrighted.  This software/database is freely available
+// to the public for use. The National Library of Medicine and the U.S.
+// Government have not placed any restriction on its use or reproduction.
+//
+// Although all reasonable efforts have been taken to ensure the accuracy
+// and reliability of the software and data, the NLM and the U.S.
+// Government do not and cannot warrant the performance or results that
+// may be obtained by using this software or data. The NLM and the U.S.
+// Government disclaim all warranties, express or implied, including
+// warranties of performance, merchantability or fitness for any particular
+// purpose.
+//
+// Please cite the author in any work or product based on this material.
+
+package main
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	hv1 "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+)
+
+func Test_createStatusPolicy_default(t *testing.T) {
+	// when
+	policy, err := createStatusPolicy(&appFlags{})
+
+	// then
+	assert.NoError(t, err)
+	assert.Equal(t, outcomeOK, policy.outcome(hv1.HealthCheckResponse_SERVING))
+	assert.Equal(t, outcomeFail, policy.outcome(hv1.HealthCheckResponse_NOT_SERVING))
+	assert.Equal(t, outcomeFail, policy.outcome(hv1.HealthCheckResponse_SERVICE_UNKNOWN))
+	assert.Equal(t, outcomeFail, policy.outcome(hv1.HealthCheckResponse_UNKNOWN))
+}
+
+func Test_createStatusPolicy(t *testing.T) {
+	// given
+	dataset := []struct {
+		flags    *appFlags
+		outcomes map[hv1.HealthCheckResponse_ServingStatus]string
+	}{
+		{&appFlags{expect: []string{"NOT_SERVING"}}, map[hv1.HealthCheckResponse_ServingStatus]string{
+			hv1.HealthCheckResponse_SERVING:     outcomeFail,
+			hv1.HealthCheckResponse_NOT_SERVING: outcomeOK,
+		}},
+		{&appFlags{expect: []string{"serving", "service_unknown"}}, map[hv1.HealthCheckResponse_ServingStatus]string{
+			hv1.HealthCheckResponse_SERVING:         outcomeOK,
+			hv1.HealthCheckResponse_SERVICE_UNKNOWN: outcomeOK,
+			hv1.HealthCheckResponse_NOT_SERVING:     outcomeFail,
+		}},
+		{&appFlags{statusMap: "NOT_SERVING=warn, SERVICE_UNKNOWN=fail"}, map[hv1.HealthCheckResponse_ServingStatus]string{
+			hv1.HealthCheckResponse_SERVING:         outcomeOK,
+			hv1.HealthCheckResponse_NOT_SERVING:     outcomeWarn,
+			hv1.HealthCheckResponse_SERVICE_UNKNOWN: outcomeFail,
+		}},
+		{&appFlags{expect: []string{"NOT_SERVING"}, statusMap: "SERVING=warn"}, map[hv1.HealthCheckResponse_ServingStatus]string{
+			hv1.HealthCheckResponse_SERVING:     outcomeWarn,
+			hv1.HealthCheckResponse_NOT_SERVING: outcomeOK,
+			hv1.HealthCheckResponse_UNKNOWN:     outcomeFail,
+		}},
+	}
+
+	for _, tt := range dataset {
+		// when
+		policy, err := createStatusPolicy(tt.flags)
+
+		// then
+		assert.NoError(t, err)
+		for servingStatus, outcome := range tt.outcomes {
+			assert.Equal(t, outcome, policy.outcome(servingStatus), fmt.Sprintf("%v %s", tt.flags.expect, tt.flags.statusMap))
+		}
+	}
+}
+
+func Test_createStatusPolicy_invalid(t *testing.T) {
+	// given
+	dataset := []*appFlags{
+		{expect: []string{"HEALTHY"}},
+		{statusMap: "NOT_SERVING"},
+		{statusMap: "NOT_SERVING=maybe"},
+		{statusMap: "DRAINING=warn"},
+	}
+
+	for _, flags := range dataset {
+		// when
+		_, err := createStatusPolicy(flags)
+
+		// then
+		assert.Error(t, err, fmt.Sprintf("%v %s", flags.expect, flags.statusMap))
+	}
+}
+
+func Test_statusPolicy_apply(t *testing.T) {
+	// given
+	notFound := status.Error(codes.NotFound, "unknown service")
+	policy := statusPolicy{notFoundAsUnknown: true}
+
+	// when
+	servingStatus, err := policy.apply(hv1.HealthCheckResponse_UNKNOWN, notFound)
+	_, defaultErr := statusPolicy{}.apply(hv1.HealthCheckResponse_UNKNOWN, notFound)
+
+	// then
+	assert.NoError(t, err)
+	assert.Equal(t, hv1.HealthCheckResponse_SERVICE_UNKNOWN, servingStatus)
+	assert.Equal(t, notFound, defaultErr)
+}
@@ -0,0 +1,154 @@
+// PUBLIC DOMAIN NOTICE
+// National Center for Biotechnology Information
+//
+// This software/database is a "United States Government Work" under the
+// terms of the United States Copyright Act.  It was written as part of
+// the author's official duties as a United States Government employee and
+// thus cannot be copyrighted.  This software/database is freely available
+// to the public for use. The National Library of Medicine and the U.S.
+// Government have not placed any restriction on its use or reproduction.
+//
+// Although all reasonable efforts have been taken to ensure the accuracy
+// and reliability of the software and data, the NLM and the U.S.
+// Government do not and cannot warrant the performance or results that
+// may be obtained by using this software or data. The NLM and the U.S.
+// Government disclaim all warranties, express or implied, including
+// warranties of performance, merchantability or fitness for any particular
+// purpose.
+//
+// Please cite the author in any work or product based on this material.
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/urfave/cli"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	requireAll = "all"
+	requireAny = "any"
+)
+
+// target is a single server address and service name pair listed in targets file
+type target struct {
+	serverAddress string
+	serviceName   string
+}
+
+func configureTargets(config *appConfig, flags *appFlags) error {
+	targets, err := loadTargets(flags.targetsFile)
+	if err != nil {
+		return fmt.Errorf("can't read targets: %s", err.Error())
+	}
+	if len(targets) == 0 {
+		return fmt.Errorf("no targets found in %s", flags.targetsFile)
+	}
+	required, err := parseRequire(flags.require, len(targets))
+	if err != nil {
+		return err
+	}
+	if flags.parallelism < 1 {
+		return fmt.Errorf("--parallelism must be positive, got %d", flags.parallelism)
+	}
+
+	config.targets = targets
+	config.required = required
+	config.parallelism = flags.parallelism
+	return nil
+}
+
+func loadTargets(path string) ([]target, error) {
+	if path == "-" {
+		return readTargets(os.Stdin)
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return readTargets(file)
+}
+
+// readTargets parses one 'server_address [service_name]' pair per line, empty lines and lines starting with # are
+// skipped
+func readTargets(reader io.Reader) (targets []target, err error) {
+	scanner := bufio.NewScanner(reader)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) == 0 || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		switch len(fields) {
+		case 1:
+			targets = append(targets, target{serverAddress: fields[0]})
+		case 2:
+			targets = append(targets, target{serverAddress: fields[0], serviceName: fields[1]})
+		default:
+			return nil, fmt.Errorf("line %d: expected 'server_address [service_name]', got '%s'", lineNo, line)
+		}
+	}
+	return targets, scanner.Err()
+}
+
+// parseRequire converts --require value into number of targets which must pass
+func parseRequire(value string, total int) (int, error) {
+	switch value {
+	case "", requireAll:
+		return total, nil
+	case requireAny:
+		return 1, nil
+	}
+	required, err := strconv.Atoi(value)
+	if err != nil || required < 1 || required > total {
+		return 0, fmt.Errorf("--require must be all, any or a number from 1 to %d, got %s", total, value)
+	}
+	return required, nil
+}
+
+// probeTargets checks all targets concurrently and prints results in the order targets are listed
+func probeTargets(w io.Writer, config *appConfig) *cli.ExitError {
+	configs := make([]*appConfig, len(config.targets))
+	results := make([]probeResult, len(config.targets))
+	semaphore := make(chan struct{}, config.parallelism)
+	var wg sync.WaitGroup
+
+	for i, t := range config.targets {
+		targetConfig := *config
+		targetConfig.serverAddress = t.serverAddress
+		targetConfig.serviceName = t.serviceName
+		targetConfig.targets = nil
+		configs[i] = &targetConfig
+
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			semaphore <- struct{}{}
+			defer func() { <-semaphore }()
+			results[i] = probe(configs[i])
+		}(i)
+	}
+	wg.Wait()
+
+	passed := 0
+	for i, result := range results {
+		exitCode := exitError(configs[i], result).ExitCode()
+		if exitCode == 0 {
+			passed++
+		}
+		printTargetResult(w, configs[i], result, exitCode)
+	}
+
+	if passed < config.required {
+		message := fmt.Sprintf("%d of %d targets passed, %d required", passed, len(results), config.required)
+		return cli.NewExitError(message, ExitCodeHealthCheckNegative)
+	}
+	return cli.NewExitError("", 0)
+}
@@ -0,0 +1,128 @@
+// PUBLIC DOMAIN NOTICE
+// National Center for Biotechnology Information
+//
+// This software/database is a "United States Government Work" under the
+// terms of the United States Copyright Act.  It was written as part of
+// the author's official duties as a United States Government employee and
+// thus cannot be copyrighted.  This software/database is freely available
+// to the public for use. The National Library of Medicine and the U.S.
+// Government have not placed any restriction on its use or reproduction.
+//
+// Although all reasonable efforts have been taken to ensure the accuracy
+// and reliability of the software and data, the NLM and the U.S.
+// Government do not and cannot warrant the performance or results that
+// may be obtained by using this software or data. The NLM and the U.S.
+// Government disclaim all warranties, express or implied, including
+// warranties of performance, merchantability or fitness for any particular
+// purpose.
+//
+// Please cite the author in any work or product based on this material.
+
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/urfave/cli"
+)
+
+func Test_readTargets(t *testing.T) {
+	// given
+	input := `
+# comment
+localhost:1234
+  localhost:1234   my.service.Foo
+unix:///run/app.sock bar
+`
+
+	// when
+	targets, err := readTargets(strings.NewReader(input))
+
+	// then
+	assert.NoError(t, err)
+	assert.Equal(t, []target{
+		{serverAddress: "localhost:1234"},
+		{serverAddress: "localhost:1234", serviceName: "my.service.Foo"},
+		{serverAddress: "unix:///run/app.sock", serviceName: "bar"},
+	}, targets)
+}
+
+func Test_readTargets_invalidLine(t *testing.T) {
+	// given
+	input := "localhost:1234\nlocalhost:1234 foo bar\n"
+
+	// when
+	_, err := readTargets(strings.NewReader(input))
+
+	// then
+	assert.EqualError(t, err, "line 2: expected 'server_address [service_name]', got 'localhost:1234 foo bar'")
+}
+
+func Test_parseRequire(t *testing.T) {
+	// given
+	dataset := []struct {
+		value         string
+		required      int
+		errorReturned bool
+	}{
+		{"", 3, false},
+		{"all", 3, false},
+		{"any", 1, false},
+		{"2", 2, false},
+		{"3", 3, false},
+		{"0", 0, true},
+		{"4", 0, true},
+		{"most", 0, true},
+	}
+
+	for _, tt := range dataset {
+		// when
+		required, err := parseRequire(tt.value, 3)
+
+		// then
+		assert.Equal(t, tt.required, required, tt.value)
+		if tt.errorReturned {
+			assert.Error(t, err, tt.value)
+		} else {
+			assert.NoError(t, err, tt.value)
+		}
+	}
+}
+
+func Test_createConfig_targets(t *testing.T) {
+	// given
+	flags := &appFlags{targetsFile: "testdata/targets.txt", parallelism: 5, require: "any"}
+
+	// when
+	config, err := createConfig(flags, cli.Args{})
+
+	// then
+	assert.NoError(t, err)
+	assert.Len(t, config.targets, 2)
+	assert.Equal(t, 1, config.required)
+	assert.Equal(t, 5, config.parallelism)
+}
+
+func Test_createConfig_targets_invalid(t *testing.T) {
+	// given
+	dataset := []struct {
+		flags   *appFlags
+		args    cli.Args
+		message string
+	}{
+		{&appFlags{targetsFile: "testdata/targets.txt", parallelism: 1}, cli.Args{"foo"}, "arguments are not allowed with targets"},
+		{&appFlags{targetsFile: "testdata/targets.txt", parallelism: 0}, cli.Args{}, "parallelism must be positive"},
+		{&appFlags{targetsFile: "testdata/targets.txt", parallelism: 1, require: "3"}, cli.Args{}, "only 2 targets"},
+		{&appFlags{targetsFile: "testdata/123098.txt", parallelism: 1}, cli.Args{}, "file does not exist"},
+	}
+
+	for _, tt := range dataset {
+		// when
+		_, err := createConfig(tt.flags, tt.args)
+
+		// then
+		assert.Error(t, err, tt.message)
+	}
+}
@@ -0,0 +1,177 @@
+// PUBLIC DOMAIN NOTICE
+// National Center for Biotechnology Information
+//
+// This software/database is a "United States Government Work" under the
+// terms of the United States Copyright Act.  It was written as part of
+// the author's official duties as a United States Government employee and
+// thus cannot be copyrighted.  This software/database is freely available
+// to the public for use. The National Library of Medicine and the U.S.
+// Government have not placed any restriction on its use or reproduction.
+//
+// Although all reasonable efforts have been taken to ensure the accuracy
+// and reliability of the software and data, the NLM and the U.S.
+// Government do not and cannot warrant the performance or results that
+// may be obtained by using this software or data. The NLM and the U.S.
+// Government disclaim all warranties, express or implied, including
+// warranties of performance, merchantability or fitness for any particular
+// purpose.
+//
+// Please cite the author in any work or product based on this material.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// probeTiming is time spent in every phase of a check attempt
+type probeTiming struct {
+	resolve   time.Duration
+	connect   time.Duration
+	handshake time.Duration
+	rpc       time.Duration
+	// untraced is time spent in the whole attempt if phases aren't traced because connection goes through a proxy
+	untraced time.Duration
+}
+
+// dial returns time spent to establish connection
+func (timing probeTiming) dial() time.Duration {
+	return timing.resolve + timing.connect + timing.handshake
+}
+
+// total returns time spent to establish connection and get health status
+func (timing probeTiming) total() time.Duration {
+	return timing.dial() + timing.rpc + timing.untraced
+}
+
+func (timing probeTiming) String() string {
+	if timing.untraced > 0 {
+		return fmt.Sprintf("total %s, phases aren't traced through proxy", timing.untraced)
+	}
+	return fmt.Sprintf("resolve %s, connect %s, tls %s, rpc %s, total %s", timing.resolve, timing.connect,
+		timing.handshake, timing.rpc, timing.total())
+}
+
+// connectionTrace records timing of connection establishment. gRPC dials in background, so the trace is updated
+// concurrently with the RPC
+type connectionTrace struct {
+	mutex  sync.Mutex
+	timing probeTiming
+	// ready is when connection became ready to send RPCs
+	ready time.Time
+}
+
+// needsTrace tells if connection should be traced: timing is printed or checked and the connection doesn't go through
+// HTTP CONNECT proxy (HTTPS_PROXY env var), which gRPC uses only with its own dialer
+func needsTrace(config *appConfig) bool {
+	if !config.verbose && config.maxLatency == 0 && config.output != outputJSON {
+		return false
+	}
+	network, address := dialAddress(config.serverAddress)
+	if network != "tcp" {
+		return true
+	}
+	// the same request gRPC checks proxy settings with
+	proxy, err := http.ProxyFromEnvironment(&http.Request{URL: &url.URL{Scheme: "https", Host: address}})
+	return err == nil && proxy == nil
+}
+
+// dialOptions makes connection established through the trace
+func (trace *connectionTrace) dialOptions(config *appConfig) []grpc.DialOption {
+	options := []grpc.DialOption{grpc.WithContextDialer(trace.dial)}
+	if config.creds != nil {
+		options = append(options, grpc.WithTransportCredentials(&tracedCredentials{config.creds, trace}))
+	}
+	return options
+}
+
+// dial connects to the address the same way gRPC does by default, with net.Dialer. Name resolution is considered
+// done when the first socket is created. gRPC passes unix socket targets as unix://path or unix:path and abstract
+// sockets as \x00name
+func (trace *connectionTrace) dial(ctx context.Context, target string) (net.Conn, error) {
+	network, address := dialAddress(target)
+	if strings.HasPrefix(target, "\x00") {
+		network, address = "unix", "@"+strings.TrimPrefix(target, "\x00")
+	}
+
+	var resolved sync.Once
+	var resolve time.Duration
+	start := time.Now()
+	dialer := &net.Dialer{
+		Control: func(string, string, syscall.RawConn) error {
+			resolved.Do(func() {
+				resolve = time.Since(start)
+			})
+			return nil
+		},
+	}
+	conn, err := dialer.DialContext(ctx, network, address)
+	elapsed := time.Since(start)
+	resolved.Do(func() {
+		// no socket was created, name resolution failed
+		resolve = elapsed
+	})
+	trace.connected(resolve, elapsed-resolve)
+	return conn, err
+}
+
+func (trace *connectionTrace) connected(resolve time.Duration, connect time.Duration) {
+	trace.mutex.Lock()
+	defer trace.mutex.Unlock()
+	trace.timing.resolve = resolve
+	trace.timing.connect = connect
+	trace.ready = time.Now()
+}
+
+func (trace *connectionTrace) handshaken(handshake time.Duration) {
+	trace.mutex.Lock()
+	defer trace.mutex.Unlock()
+	trace.timing.handshake = handshake
+	trace.ready = time.Now()
+}
+
+// rpcTiming completes connection timing with time spent in RPC which started at given time. Time before connection
+// became ready is not counted
+func (trace *connectionTrace) rpcTiming(rpcStart time.Time) probeTiming {
+	end := time.Now()
+	trace.mutex.Lock()
+	defer trace.mutex.Unlock()
+	timing := trace.timing
+	if trace.ready.After(rpcStart) {
+		rpcStart = trace.ready
+	}
+	if !trace.ready.IsZero() {
+		timing.rpc = end.Sub(rpcStart)
+	}
+	return timing
+}
+
+// tracedCredentials times TLS handshake
+type tracedCredentials struct {
+	credentials.TransportCredentials
+	trace *connectionTrace
+}
+
+func (creds *tracedCredentials) ClientHandshake(ctx context.Context, authority string, rawConn net.Conn) (
+	net.Conn, credentials.AuthInfo, error) {
+	start := time.Now()
+	conn, authInfo, err := creds.TransportCredentials.ClientHandshake(ctx, authority, rawConn)
+	if err == nil {
+		creds.trace.handshaken(time.Since(start))
+	}
+	return conn, authInfo, err
+}
+
+func (creds *tracedCredentials) Clone() credentials.TransportCredentials {
+	return &tracedCredentials{creds.TransportCredentials.Clone(), creds.trace}
+}
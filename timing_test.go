@@ -0,0 +1,119 @@
+// PUBLIC DOMAIN NOTICE
+// National Center for Biotechnology Information
+//
+// This software/database is a "United States Government Work" under the
+// terms of the United States Copyright Act.  It was written as part of
+// the author's official duties as a United States Government employee and
+// thus cannot be copyrighted.  This software/database is freely available
+// to the public for use. The National Library of Medicine and the U.S.
+// Government have not placed any restriction on its use or reproduction.
+//
+// Although all reasonable efforts have been taken to ensure the accuracy
+// and reliability of the software and data, the NLM and the U.S.
+// Government do not and cannot warrant the performance or results that
+// may be obtained by using this software or data. The NLM and the U.S.
+// Government disclaim all warranties, express or implied, including
+// warranties of performance, merchantability or fitness for any particular
+// purpose.
+//
+// Please cite the author in any work or product based on this material.
+
+package main
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_probeTiming(t *testing.T) {
+	// given
+	timing := probeTiming{
+		resolve:   time.Millisecond,
+		connect:   2 * time.Millisecond,
+		handshake: 3 * time.Millisecond,
+		rpc:       4 * time.Millisecond,
+	}
+
+	// then
+	assert.Equal(t, 6*time.Millisecond, timing.dial())
+	assert.Equal(t, 10*time.Millisecond, timing.total())
+	assert.Equal(t, "resolve 1ms, connect 2ms, tls 3ms, rpc 4ms, total 10ms", timing.String())
+}
+
+func Test_probeTiming_untraced(t *testing.T) {
+	// given
+	timing := probeTiming{untraced: 5 * time.Millisecond}
+
+	// then
+	assert.Equal(t, 5*time.Millisecond, timing.total())
+	assert.Equal(t, "total 5ms, phases aren't traced through proxy", timing.String())
+}
+
+func Test_needsTrace(t *testing.T) {
+	// given
+	dataset := []struct {
+		config   *appConfig
+		expected bool
+		message  string
+	}{
+		{&appConfig{serverAddress: "localhost:1234"}, false, "should not trace, timing is not used"},
+		{&appConfig{serverAddress: "localhost:1234", verbose: true}, true, "should trace, timing is printed"},
+		{&appConfig{serverAddress: "localhost:1234", maxLatency: time.Second}, true, "should trace, timing is checked"},
+		{&appConfig{serverAddress: "localhost:1234", output: outputJSON}, true, "should trace, timing is printed"},
+		{&appConfig{serverAddress: "unix:///run/app.sock", verbose: true}, true, "should trace, sockets are not proxied"},
+	}
+
+	for _, tt := range dataset {
+		// then
+		assert.Equal(t, tt.expected, needsTrace(tt.config), tt.message)
+	}
+}
+
+func Test_connectionTrace_rpcTiming(t *testing.T) {
+	// given
+	trace := &connectionTrace{}
+	rpcStart := time.Now().Add(-time.Second)
+
+	// when
+	notConnected := trace.rpcTiming(rpcStart)
+	trace.connected(time.Millisecond, 2*time.Millisecond)
+	connected := trace.rpcTiming(rpcStart)
+
+	// then
+	assert.Equal(t, probeTiming{}, notConnected)
+	assert.Equal(t, time.Millisecond, connected.resolve)
+	assert.Equal(t, 2*time.Millisecond, connected.connect)
+	assert.True(t, connected.rpc < time.Second, "time before connection is ready is not counted as rpc")
+}
+
+func Test_connectionTrace_dial(t *testing.T) {
+	// given
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	defer listener.Close()
+	_, port, _ := net.SplitHostPort(listener.Addr().String())
+	trace := &connectionTrace{}
+
+	// when
+	conn, err := trace.dial(context.Background(), net.JoinHostPort("localhost", port))
+
+	// then
+	assert.NoError(t, err)
+	conn.Close()
+	assert.False(t, trace.ready.IsZero())
+}
+
+func Test_connectionTrace_dial_lookupFailure(t *testing.T) {
+	// given
+	trace := &connectionTrace{}
+
+	// when
+	_, err := trace.dial(context.Background(), "nosuchhost.invalid:1234")
+
+	// then
+	assert.Regexp(t, "^dial tcp: lookup nosuchhost.invalid", err.Error())
+}
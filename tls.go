@@ -0,0 +1,204 @@
+// PUBLIC DOMAIN NOTICE
+// National Center for Biotechnology Information
+//
+// This software/database is a "United States Government Work" under the
+// terms of the United States Copyright Act.  It was written as part of
+// the author's official duties as a United States Government employee and
+// thus cannot be copyrighted.  This software/database is freely available
+// to the public for use. The National Library of Medicine and the U.S.
+// Government have not placed any restriction on its use or reproduction.
+//
+// Although all reasonable efforts have been taken to ensure the accuracy
+// and reliability of the software and data, the NLM and the U.S.
+// Government do not and cannot warrant the performance or results that
+// may be obtained by using this software or data. The NLM and the U.S.
+// Government disclaim all warranties, express or implied, including
+// warranties of performance, merchantability or fitness for any particular
+// purpose.
+//
+// Please cite the author in any work or product based on this material.
+
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+)
+
+// peerCheck verifies certificate chain presented by the server, leaf certificate goes first. Verified chains are
+// empty if standard verification is disabled with --tls-insecure
+type peerCheck func(chain []*x509.Certificate, verifiedChains [][]*x509.Certificate) error
+
+// verifyPeerCertificate runs checks against certificates presented by the server. It is called after standard
+// verification (if not disabled with --tls-insecure) succeeds
+func verifyPeerCertificate(checks []peerCheck) func(rawCerts [][]byte, verifiedChains [][]*x509.Certificate) error {
+	return func(rawCerts [][]byte, verifiedChains [][]*x509.Certificate) error {
+		chain := make([]*x509.Certificate, 0, len(rawCerts))
+		for _, raw := range rawCerts {
+			certificate, err := x509.ParseCertificate(raw)
+			if err != nil {
+				return fmt.Errorf("can't parse server certificate: %s", err.Error())
+			}
+			chain = append(chain, certificate)
+		}
+		if len(chain) == 0 {
+			return fmt.Errorf("server presented no certificate")
+		}
+		for _, check := range checks {
+			if err := check(chain, verifiedChains); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// expectSAN checks that server certificate has subject alternative name. IP addresses are matched against IP SANs,
+// values with :// against URI SANs and anything else against DNS SANs
+func expectSAN(san string) peerCheck {
+	return func(chain []*x509.Certificate, _ [][]*x509.Certificate) error {
+		leaf := chain[0]
+		if ip := net.ParseIP(san); ip != nil {
+			for _, actual := range leaf.IPAddresses {
+				if actual.Equal(ip) {
+					return nil
+				}
+			}
+		} else if strings.Contains(san, "://") {
+			for _, actual := range leaf.URIs {
+				if actual.String() == san {
+					return nil
+				}
+			}
+		} else {
+			for _, actual := range leaf.DNSNames {
+				if strings.EqualFold(actual, san) {
+					return nil
+				}
+			}
+		}
+		return fmt.Errorf("server certificate has no SAN %s, found %s", san, strings.Join(listSANs(leaf), ", "))
+	}
+}
+
+// expectSPIFFEID checks that server certificate holds SPIFFE ID, which is URI SAN with spiffe scheme
+func expectSPIFFEID(id string) peerCheck {
+	return func(chain []*x509.Certificate, _ [][]*x509.Certificate) error {
+		var found []string
+		for _, uri := range chain[0].URIs {
+			if uri.Scheme == "spiffe" {
+				found = append(found, uri.String())
+			}
+		}
+		switch {
+		case len(found) == 0:
+			return fmt.Errorf("server certificate has no SPIFFE ID, expected %s", id)
+		case len(found) > 1:
+			return fmt.Errorf("server certificate has multiple SPIFFE IDs %s", strings.Join(found, ", "))
+		case found[0] != id:
+			return fmt.Errorf("server SPIFFE ID %s doesn't match expected %s", found[0], id)
+		}
+		return nil
+	}
+}
+
+// pinPrefix prefixes base64 encoded SHA-256 hash of certificate public key
+const pinPrefix = "sha256/"
+
+// validatePin checks that pin is base64 encoded SHA-256 hash prefixed with sha256/
+func validatePin(pin string) error {
+	hash, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(pin, pinPrefix))
+	if !strings.HasPrefix(pin, pinPrefix) || err != nil || len(hash) != sha256.Size {
+		return fmt.Errorf("invalid pin %s, must be sha256/ followed by base64 encoded SHA-256 hash of public key", pin)
+	}
+	return nil
+}
+
+// expectPin checks that public key of a trusted certificate of server chain matches any of the pins. Pinning the key
+// of an intermediate or root CA allows server certificates to be reissued
+func expectPin(pins []string) peerCheck {
+	return func(chain []*x509.Certificate, verifiedChains [][]*x509.Certificate) error {
+		var found []string
+		listed := map[string]bool{}
+		for _, certificate := range trustedCertificates(chain, verifiedChains) {
+			fingerprint := spkiFingerprint(certificate)
+			for _, pin := range pins {
+				if fingerprint == pin {
+					return nil
+				}
+			}
+			if !listed[fingerprint] {
+				found = append(found, fingerprint)
+				listed[fingerprint] = true
+			}
+		}
+		return fmt.Errorf("no server certificate matches pinned public keys, found %s", strings.Join(found, ", "))
+	}
+}
+
+// trustedCertificates returns certificates of verified chains. If the chain isn't verified (--tls-insecure) these are
+// the leaf, which key the server has proven to hold during handshake, and certificates following it as long as every
+// one of them signs the previous one. Certificates the server merely appended to the chain are never trusted
+func trustedCertificates(chain []*x509.Certificate, verifiedChains [][]*x509.Certificate) []*x509.Certificate {
+	var trusted []*x509.Certificate
+	if len(verifiedChains) > 0 {
+		for _, verified := range verifiedChains {
+			trusted = append(trusted, verified...)
+		}
+		return trusted
+	}
+	trusted = append(trusted, chain[0])
+	for i := 1; i < len(chain); i++ {
+		if chain[i-1].CheckSignatureFrom(chain[i]) != nil {
+			break
+		}
+		trusted = append(trusted, chain[i])
+	}
+	return trusted
+}
+
+// listSANs returns all subject alternative names of the certificate
+func listSANs(certificate *x509.Certificate) []string {
+	sans := append([]string{}, certificate.DNSNames...)
+	for _, ip := range certificate.IPAddresses {
+		sans = append(sans, ip.String())
+	}
+	for _, uri := range certificate.URIs {
+		sans = append(sans, uri.String())
+	}
+	if len(sans) == 0 {
+		sans = append(sans, "none")
+	}
+	return sans
+}
+
+// earliestExpiring returns certificate of the chain which expires first, nil if the chain is empty
+func earliestExpiring(chain []*x509.Certificate) *x509.Certificate {
+	var earliest *x509.Certificate
+	for _, certificate := range chain {
+		if earliest == nil || certificate.NotAfter.Before(earliest.NotAfter) {
+			earliest = certificate
+		}
+	}
+	return earliest
+}
+
+// describeExpiry tells when certificate expires, time left is rounded to minutes
+func describeExpiry(certificate *x509.Certificate) string {
+	validity := time.Until(certificate.NotAfter).Round(time.Minute)
+	if validity <= 0 {
+		return fmt.Sprintf("certificate %s expired %s ago", certificate.Subject.String(), -validity)
+	}
+	return fmt.Sprintf("certificate %s expires in %s", certificate.Subject.String(), validity)
+}
+
+// spkiFingerprint returns base64 encoded SHA-256 hash of certificate public key prefixed with sha256/
+func spkiFingerprint(certificate *x509.Certificate) string {
+	hash := sha256.Sum256(certificate.RawSubjectPublicKeyInfo)
+	return pinPrefix + base64.StdEncoding.EncodeToString(hash[:])
+}
@@ -0,0 +1,254 @@
+// PUBLIC DOMAIN NOTICE
+// National Center for Biotechnology Information
+//
+// This software/database is a "United States Government Work" under the
+// terms of the United States Copyright Act.  It was written as part of
+// the author's official duties as a United States Government employee and
+// thus cannot be copyrighted.  This software/database is freely available
+// to the public for use. The National Library of Medicine and the U.S.
+// Government have not placed any restriction on its use or reproduction.
+//
+// Although all reasonable efforts have been taken to ensure the accuracy
+// and reliability of the software and data, the NLM and the U.S.
+// Government do not and cannot warrant the performance or results that
+// may be obtained by using this software or data. The NLM and the U.S.
+// Government disclaim all warranties, express or implied, including
+// warranties of performance, merchantability or fitness for any particular
+// purpose.
+//
+// Please cite the author in any work or product based on this material.
+
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestCertificate(dnsNames []string, ips []string, uris []string) *x509.Certificate {
+	certificate := &x509.Certificate{DNSNames: dnsNames}
+	for _, ip := range ips {
+		certificate.IPAddresses = append(certificate.IPAddresses, net.ParseIP(ip))
+	}
+	for _, uri := range uris {
+		parsed, _ := url.Parse(uri)
+		certificate.URIs = append(certificate.URIs, parsed)
+	}
+	return certificate
+}
+
+func Test_expectSAN(t *testing.T) {
+	// given
+	certificate := newTestCertificate([]string{"app.example.com"}, []string{"10.0.0.1"},
+		[]string{"spiffe://example.org/ns/default/sa/app"})
+	dataset := []struct {
+		san           string
+		errorReturned bool
+	}{
+		{"app.example.com", false},
+		{"APP.example.com", false},
+		{"10.0.0.1", false},
+		{"spiffe://example.org/ns/default/sa/app", false},
+		{"other.example.com", true},
+		{"10.0.0.2", true},
+		{"spiffe://example.org/ns/default/sa/other", true},
+		{"10.0.0.1.example.com", true},
+	}
+
+	for _, tt := range dataset {
+		// when
+		err := expectSAN(tt.san)([]*x509.Certificate{certificate}, nil)
+
+		// then
+		if tt.errorReturned {
+			assert.Error(t, err, tt.san)
+		} else {
+			assert.NoError(t, err, tt.san)
+		}
+	}
+}
+
+func Test_expectSAN_errorListsActualSANs(t *testing.T) {
+	// given
+	certificate := newTestCertificate([]string{"app.example.com"}, []string{"10.0.0.1"}, nil)
+
+	// when
+	err := expectSAN("localhost")([]*x509.Certificate{certificate}, nil)
+
+	// then
+	assert.EqualError(t, err, "server certificate has no SAN localhost, found app.example.com, 10.0.0.1")
+}
+
+func Test_expectSPIFFEID(t *testing.T) {
+	// given
+	expected := "spiffe://example.org/ns/default/sa/app"
+	dataset := []struct {
+		certificate *x509.Certificate
+		message     string
+	}{
+		{newTestCertificate(nil, nil, []string{expected}), ""},
+		{newTestCertificate(nil, nil, []string{"https://example.org", expected}), ""},
+		{newTestCertificate([]string{"app.example.com"}, nil, nil),
+			"server certificate has no SPIFFE ID, expected spiffe://example.org/ns/default/sa/app"},
+		{newTestCertificate(nil, nil, []string{"spiffe://example.org/ns/default/sa/other"}),
+			"server SPIFFE ID spiffe://example.org/ns/default/sa/other doesn't match expected " +
+				"spiffe://example.org/ns/default/sa/app"},
+		{newTestCertificate(nil, nil, []string{expected, "spiffe://example.org/ns/default/sa/other"}),
+			"server certificate has multiple SPIFFE IDs spiffe://example.org/ns/default/sa/app, " +
+				"spiffe://example.org/ns/default/sa/other"},
+	}
+
+	for _, tt := range dataset {
+		// when
+		err := expectSPIFFEID(expected)([]*x509.Certificate{tt.certificate}, nil)
+
+		// then
+		if len(tt.message) > 0 {
+			assert.EqualError(t, err, tt.message)
+		} else {
+			assert.NoError(t, err)
+		}
+	}
+}
+
+func Test_verifyPeerCertificate_failsOnGarbage(t *testing.T) {
+	// given
+	verify := verifyPeerCertificate([]peerCheck{expectSAN("localhost")})
+
+	// when
+	err := verify([][]byte{[]byte("garbage")}, nil)
+
+	// then
+	assert.Contains(t, err.Error(), "can't parse server certificate")
+}
+
+func Test_spkiFingerprint(t *testing.T) {
+	// given
+	encoded, err := ioutil.ReadFile("acctest/x509/certificate.pem")
+	assert.NoError(t, err)
+	block, _ := pem.Decode(encoded)
+	certificate, err := x509.ParseCertificate(block.Bytes)
+	assert.NoError(t, err)
+
+	// when
+	fingerprint := spkiFingerprint(certificate)
+
+	// then
+	assert.Equal(t, "sha256/pG92O9tYUKiRIL4Pv1bibXBilZYbQN/1V74aoX8nttI=", fingerprint)
+}
+
+func Test_validatePin(t *testing.T) {
+	// given
+	dataset := []struct {
+		pin           string
+		errorReturned bool
+	}{
+		{"sha256/pG92O9tYUKiRIL4Pv1bibXBilZYbQN/1V74aoX8nttI=", false},
+		{"pG92O9tYUKiRIL4Pv1bibXBilZYbQN/1V74aoX8nttI=", true},
+		{"sha1/pG92O9tYUKiRIL4Pv1bibXBilZYbQN/1V74aoX8nttI=", true},
+		{"sha256/not base64", true},
+		{"sha256/AQID", true},
+	}
+
+	for _, tt := range dataset {
+		// when
+		err := validatePin(tt.pin)
+
+		// then
+		if tt.errorReturned {
+			assert.Error(t, err, tt.pin)
+		} else {
+			assert.NoError(t, err, tt.pin)
+		}
+	}
+}
+
+// newSignedCertificate creates certificate with new key signed by parent, self-signed if parent is nil
+func newSignedCertificate(t *testing.T, name string, isCA bool, parent *x509.Certificate,
+	parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		BasicConstraintsValid: true,
+		IsCA:                  isCA,
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+	}
+	if parent == nil {
+		parent, parentKey = template, key
+	}
+	raw, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certificate, err := x509.ParseCertificate(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return certificate, key
+}
+
+func Test_expectPin(t *testing.T) {
+	// given
+	ca, caKey := newSignedCertificate(t, "ca", true, nil, nil)
+	intermediate, intermediateKey := newSignedCertificate(t, "intermediate", true, ca, caKey)
+	leaf, _ := newSignedCertificate(t, "leaf", false, intermediate, intermediateKey)
+	other, _ := newSignedCertificate(t, "other", true, nil, nil)
+	chain := []*x509.Certificate{leaf, intermediate, ca}
+
+	// when
+	leafErr := expectPin([]string{spkiFingerprint(leaf)})(chain, nil)
+	caErr := expectPin([]string{spkiFingerprint(other), spkiFingerprint(ca)})(chain, nil)
+	otherErr := expectPin([]string{spkiFingerprint(other)})(chain, nil)
+
+	// then
+	assert.NoError(t, leafErr)
+	assert.NoError(t, caErr)
+	assert.EqualError(t, otherErr, "no server certificate matches pinned public keys, found "+
+		spkiFingerprint(leaf)+", "+spkiFingerprint(intermediate)+", "+spkiFingerprint(ca))
+}
+
+func Test_expectPin_ignoresCertificatesNotSigningTheLeaf(t *testing.T) {
+	// given
+	ca, _ := newSignedCertificate(t, "ca", true, nil, nil)
+	attacker, _ := newSignedCertificate(t, "attacker", false, nil, nil)
+
+	// when
+	err := expectPin([]string{spkiFingerprint(ca)})([]*x509.Certificate{attacker, ca}, nil)
+
+	// then
+	assert.EqualError(t, err, "no server certificate matches pinned public keys, found "+spkiFingerprint(attacker))
+}
+
+func Test_expectPin_verifiedChains(t *testing.T) {
+	// given
+	ca, caKey := newSignedCertificate(t, "ca", true, nil, nil)
+	leaf, _ := newSignedCertificate(t, "leaf", false, ca, caKey)
+	pinned, _ := newSignedCertificate(t, "pinned", true, nil, nil)
+	check := expectPin([]string{spkiFingerprint(pinned)})
+
+	// when
+	appendedErr := check([]*x509.Certificate{leaf, pinned}, [][]*x509.Certificate{{leaf, ca}})
+	verifiedErr := check([]*x509.Certificate{leaf}, [][]*x509.Certificate{{leaf, ca}, {leaf, pinned}})
+
+	// then
+	assert.Error(t, appendedErr, "should fail, pinned certificate is not part of verified chain")
+	assert.NoError(t, verifiedErr)
+}
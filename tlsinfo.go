@@ -0,0 +1,223 @@
+// PUBLIC DOMAIN NOTICE
+// National Center for Biotechnology Information
+//
+// This software/database is a "United States Government Work" under the
+// terms of the United States Copyright Act.  It was written as part of
+// the author's official duties as a United States Government employee and
+// thus cannot be copyrighted.  This software/database is freely available
+// to the public for use. The National Library of Medicine and the U.S.
+// Government have not placed any restriction on its use or reproduction.
+//
+// Although all reasonable efforts have been taken to ensure the accuracy
+// and reliability of the software and data, the NLM and the U.S.
+// Government do not and cannot warrant the performance or results that
+// may be obtained by using this software or data. The NLM and the U.S.
+// Government disclaim all warranties, express or implied, including
+// warranties of performance, merchantability or fitness for any particular
+// purpose.
+//
+// Please cite the author in any work or product based on this material.
+
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"github.com/urfave/cli"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"time"
+)
+
+func tlsInfoCommand() cli.Command {
+	flags := &appFlags{}
+	return cli.Command{
+		Name:  "tls-info",
+		Usage: "print details of TLS handshake with the server and tell whether its certificate passes verification",
+		Description: "Connects with configured TLS settings and prints TLS version, cipher suite, ALPN protocol, SNI " +
+			"and certificate chain presented by the server",
+		ArgsUsage:    "server_address",
+		HideHelp:     true,
+		OnUsageError: onCommandUsageError,
+		Flags:        append(connectionFlags(flags), legacyExitCodesFlag(flags)),
+		Action: func(c *cli.Context) error {
+			if len(c.Args()) != 1 {
+				return onCommandUsageError(c, fmt.Errorf("exactly 1 argument is required"), false)
+			}
+			config, err := createConfig(flags, c.Args())
+			if err != nil {
+				return onCommandUsageError(c, err, false)
+			}
+			if config.creds == nil {
+				err = fmt.Errorf("one of --tls, --tls-insecure, --tls-cafile or --tls-capath is required")
+				return onCommandUsageError(c, err, false)
+			}
+			// settings are already validated by createConfig
+			tlsConfig, err := createTLSConfig(flags)
+			if err != nil {
+				return cli.NewExitError(err.Error(), ExitCodeUnexpected)
+			}
+			return tlsInfoMain(os.Stdout, config, tlsConfig)
+		},
+	}
+}
+
+// tlsInfoMain performs TLS handshake and prints its outcome. Certificate verification is done after the handshake,
+// so that the chain is printed even if the server can't be trusted
+func tlsInfoMain(w io.Writer, config *appConfig, tlsConfig *tls.Config) *cli.ExitError {
+	network, address := dialAddress(config.serverAddress)
+	handshakeConfig := tlsConfig.Clone()
+	handshakeConfig.ServerName = serverName(config, tlsConfig)
+	handshakeConfig.NextProtos = []string{"h2"} // the same as gRPC does
+	handshakeConfig.InsecureSkipVerify = true
+	handshakeConfig.VerifyPeerCertificate = nil
+
+	deadline := time.Now().Add(config.timeout)
+	dialer := &net.Dialer{Deadline: deadline}
+	rawConnection, err := dialer.Dial(network, address)
+	if err != nil {
+		return cli.NewExitError(fmt.Sprintf("can't connect to server: %s", err.Error()),
+			tlsInfoExitCode(config, err, ExitCodeConnectionFailed))
+	}
+	defer rawConnection.Close()
+	rawConnection.SetDeadline(deadline)
+	connection := tls.Client(rawConnection, handshakeConfig)
+	err = connection.Handshake()
+	if err != nil {
+		return cli.NewExitError(fmt.Sprintf("TLS handshake failed: %s", err.Error()),
+			tlsInfoExitCode(config, err, ExitCodeTLSFailed))
+	}
+
+	state := connection.ConnectionState()
+	verifyErr := verifyState(tlsConfig, handshakeConfig.ServerName, state)
+	var verification string
+	switch {
+	case verifyErr != nil:
+		verification = fmt.Sprintf("failed: %s", verifyErr.Error())
+	case !tlsConfig.InsecureSkipVerify:
+		verification = "passed"
+	case tlsConfig.VerifyPeerCertificate != nil:
+		verification = "passed, chain is not verified (--tls-insecure)"
+	default:
+		verification = "skipped (--tls-insecure)"
+	}
+
+	alpn := state.NegotiatedProtocol
+	if len(alpn) == 0 {
+		alpn = "none"
+	}
+	fmt.Fprintf(w, "Server address:  %s\n", config.serverAddress)
+	fmt.Fprintf(w, "SNI:             %s\n", handshakeConfig.ServerName)
+	fmt.Fprintf(w, "TLS version:     %s\n", tls.VersionName(state.Version))
+	fmt.Fprintf(w, "Cipher suite:    %s\n", tls.CipherSuiteName(state.CipherSuite))
+	fmt.Fprintf(w, "ALPN protocol:   %s\n", alpn)
+	fmt.Fprintf(w, "Verification:    %s\n", verification)
+	fmt.Fprintf(w, "Certificate chain:\n")
+	for i, certificate := range state.PeerCertificates {
+		printCertificate(w, i, certificate)
+	}
+
+	if verifyErr != nil {
+		return cli.NewExitError("certificate verification failed", tlsInfoExitCode(config, verifyErr, ExitCodeTLSFailed))
+	}
+	return cli.NewExitError("", 0)
+}
+
+// tlsInfoExitCode tells class of connection or handshake error by exit code, the same way failureExitCode does for
+// gRPC errors. Errors other than name resolution failure and timeout get given code
+func tlsInfoExitCode(config *appConfig, err error, code int) int {
+	if config.legacyExitCodes {
+		return ExitCodeUnexpected
+	}
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		return ExitCodeResolveFailed
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return ExitCodeTimeout
+	}
+	return code
+}
+
+// verifyState verifies server certificate chain the same way it is done during regular handshake
+func verifyState(tlsConfig *tls.Config, serverName string, state tls.ConnectionState) error {
+	chain := state.PeerCertificates
+	if len(chain) == 0 {
+		return fmt.Errorf("server presented no certificate")
+	}
+	var verifiedChains [][]*x509.Certificate
+	if !tlsConfig.InsecureSkipVerify {
+		intermediates := x509.NewCertPool()
+		for _, certificate := range chain[1:] {
+			intermediates.AddCert(certificate)
+		}
+		var err error
+		verifiedChains, err = chain[0].Verify(x509.VerifyOptions{
+			Roots:         tlsConfig.RootCAs,
+			DNSName:       serverName,
+			Intermediates: intermediates,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	if tlsConfig.VerifyPeerCertificate != nil {
+		rawCerts := make([][]byte, len(chain))
+		for i, certificate := range chain {
+			rawCerts[i] = certificate.Raw
+		}
+		return tlsConfig.VerifyPeerCertificate(rawCerts, verifiedChains)
+	}
+	return nil
+}
+
+func printCertificate(w io.Writer, i int, certificate *x509.Certificate) {
+	fmt.Fprintf(w, "%2d Subject:      %s\n", i, certificate.Subject.String())
+	fmt.Fprintf(w, "   SANs:         %s\n", strings.Join(listSANs(certificate), ", "))
+	fmt.Fprintf(w, "   Issuer:       %s\n", certificate.Issuer.String())
+	fmt.Fprintf(w, "   Serial:       %X\n", certificate.SerialNumber)
+	fmt.Fprintf(w, "   Not before:   %s\n", certificate.NotBefore.UTC().Format(time.RFC3339))
+	fmt.Fprintf(w, "   Not after:    %s\n", certificate.NotAfter.UTC().Format(time.RFC3339))
+	fmt.Fprintf(w, "   SPKI SHA-256: %s\n", spkiFingerprint(certificate))
+}
+
+// dialAddress converts gRPC target into network and address accepted by net.Dial
+func dialAddress(target string) (network string, address string) {
+	switch {
+	case strings.HasPrefix(target, "unix://"):
+		return "unix", strings.TrimPrefix(target, "unix://")
+	case strings.HasPrefix(target, "unix:"):
+		return "unix", strings.TrimPrefix(target, "unix:")
+	case strings.HasPrefix(target, "unix-abstract:"):
+		return "unix", "@" + strings.TrimPrefix(target, "unix-abstract:")
+	case strings.HasPrefix(target, "dns:///"):
+		return "tcp", strings.TrimPrefix(target, "dns:///")
+	default:
+		return "tcp", target
+	}
+}
+
+// serverName returns name sent as SNI and used for verification: --tls-server-name, host of --authority or host of
+// server address, whichever is set first. Unix socket targets default to localhost as in gRPC
+func serverName(config *appConfig, tlsConfig *tls.Config) string {
+	if len(tlsConfig.ServerName) > 0 {
+		return tlsConfig.ServerName
+	}
+	authority := config.authority
+	if len(authority) == 0 {
+		network, address := dialAddress(config.serverAddress)
+		if network == "unix" {
+			return "localhost"
+		}
+		authority = address
+	}
+	if host, _, err := net.SplitHostPort(authority); err == nil {
+		return host
+	}
+	return authority
+}
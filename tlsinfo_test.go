@@ -0,0 +1,105 @@
+// PUBLIC DOMAIN NOTICE
+// National Center for Biotechnology Information
+//
+// This software/database is a "United States Government Work" under the
+// terms of the United States Copyright Act.  It was written as part of
+// the author's official duties as a United States Government employee and
+// thus cannot be copyrighted.  This software/database is freely available
+// to the public for use. The National Library of Medicine and the U.S.
+// Government have not placed any restriction on its use or reproduction.
+//
+// Although all reasonable efforts have been taken to ensure the accuracy
+// and reliability of the software and data, the NLM and the U.S.
+// Government do not and cannot warrant the performance or results that
+// may be obtained by using this software or data. The NLM and the U.S.
+// Government disclaim all warranties, express or implied, including
+// warranties of performance, merchantability or fitness for any particular
+// purpose.
+//
+// Please cite the author in any work or product based on this material.
+
+package main
+
+import (
+	"crypto/tls"
+	"errors"
+	"net"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_dialAddress(t *testing.T) {
+	// given
+	dataset := []struct {
+		target  string
+		network string
+		address string
+	}{
+		{"localhost:1234", "tcp", "localhost:1234"},
+		{"dns:///localhost:1234", "tcp", "localhost:1234"},
+		{"unix:///run/app.sock", "unix", "/run/app.sock"},
+		{"unix:app.sock", "unix", "app.sock"},
+		{"unix-abstract:app", "unix", "@app"},
+	}
+
+	for _, tt := range dataset {
+		// when
+		network, address := dialAddress(tt.target)
+
+		// then
+		assert.Equal(t, tt.network, network, tt.target)
+		assert.Equal(t, tt.address, address, tt.target)
+	}
+}
+
+func Test_serverName(t *testing.T) {
+	// given
+	dataset := []struct {
+		config     *appConfig
+		tlsConfig  *tls.Config
+		serverName string
+	}{
+		{&appConfig{serverAddress: "10.0.0.1:1234"}, &tls.Config{}, "10.0.0.1"},
+		{&appConfig{serverAddress: "localhost"}, &tls.Config{}, "localhost"},
+		{&appConfig{serverAddress: "10.0.0.1:1234", authority: "app.example.com:443"}, &tls.Config{}, "app.example.com"},
+		{&appConfig{serverAddress: "10.0.0.1:1234", authority: "app.example.com"}, &tls.Config{}, "app.example.com"},
+		{&appConfig{serverAddress: "10.0.0.1:1234", authority: "app.example.com"},
+			&tls.Config{ServerName: "other.example.com"}, "other.example.com"},
+		{&appConfig{serverAddress: "unix:///run/app.sock"}, &tls.Config{}, "localhost"},
+	}
+
+	for _, tt := range dataset {
+		// when
+		name := serverName(tt.config, tt.tlsConfig)
+
+		// then
+		assert.Equal(t, tt.serverName, name, tt.config.serverAddress)
+	}
+}
+
+func Test_tlsInfoExitCode(t *testing.T) {
+	// given
+	dataset := []struct {
+		err      error
+		legacy   bool
+		expected int
+	}{
+		{&net.OpError{Op: "dial", Net: "tcp", Err: &net.DNSError{Err: "no such host", Name: "foo"}}, false,
+			ExitCodeResolveFailed},
+		{&net.OpError{Op: "dial", Net: "tcp", Err: &net.DNSError{Err: "i/o timeout", IsTimeout: true}}, false,
+			ExitCodeResolveFailed},
+		{&net.OpError{Op: "read", Net: "tcp", Err: os.ErrDeadlineExceeded}, false, ExitCodeTimeout},
+		{errors.New("tls: first record does not look like a TLS handshake"), false, ExitCodeTLSFailed},
+		{errors.New("tls: first record does not look like a TLS handshake"), true, ExitCodeUnexpected},
+	}
+
+	for _, tt := range dataset {
+		// when
+		code := tlsInfoExitCode(&appConfig{legacyExitCodes: tt.legacy}, tt.err, ExitCodeTLSFailed)
+
+		// then
+		assert.Equal(t, tt.expected, code, tt.err.Error())
+	}
+}
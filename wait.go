@@ -0,0 +1,127 @@
+// PUBLIC DOMAIN NOTICE
+// National Center for Biotechnology Information
+//
+// This software/database is a "United States Government Work" under the
+// terms of the United States Copyright Act.  It was written as part of
+// the author's official duties as a United States Government employee and
+// thus cannot be copyrighted.  This software/database is freely available
+// to the public for use. The National Library of Medicine and the U.S.
+// Government have not placed any restriction on its use or reproduction.
+//
+// Although all reasonable efforts have been taken to ensure the accuracy
+// and reliability of the software and data, the NLM and the U.S.
+// Government do not and cannot warrant the performance or results that
+// may be obtained by using this software or data. The NLM and the U.S.
+// Government disclaim all warranties, express or implied, including
+// warranties of performance, merchantability or fitness for any particular
+// purpose.
+//
+// Please cite the author in any work or product based on this material.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/urfave/cli"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	hv1 "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+	"io"
+	"os"
+	"time"
+)
+
+// errStatusReached stops Health.Watch stream once awaited status is received
+var errStatusReached = errors.New("awaited status reached")
+
+// waitMain waits until the service reports status given by --wait-for keeping single connection open. Health.Watch
+// is used if the server implements it, otherwise Health.Check is polled every interval. Progress is reported to stderr
+func waitMain(w io.Writer, config *appConfig) *cli.ExitError {
+	ctx, cancel := context.WithTimeout(context.Background(), config.waitTimeout)
+	defer cancel()
+	interrupted, interrupt := context.WithCancel(ctx)
+	defer interrupt()
+	cancelOnInterrupt(interrupt)
+
+	connection, err := connect(interrupted, config)
+	if err != nil {
+		return cli.NewExitError(fmt.Sprintf("can't connect to application: %s", err.Error()), ExitCodeUnexpected)
+	}
+	defer connection.Close()
+
+	progress := &waitProgress{config: config}
+	useWatch := true
+	for {
+		if useWatch {
+			err = watch(interrupted, connection, config.serviceName, config.timeout,
+				func(servingStatus hv1.HealthCheckResponse_ServingStatus) error {
+					progress.report(servingStatus, nil)
+					if servingStatus.String() == config.waitFor {
+						return errStatusReached
+					}
+					return nil
+				})
+			if status.Code(err) == codes.Unimplemented {
+				fmt.Fprintf(os.Stderr, "%s server doesn't implement Health.Watch, polling Health.Check every %s\n",
+					timestamp(), config.interval)
+				useWatch = false
+				continue
+			}
+			if err != errStatusReached && interrupted.Err() == nil {
+				progress.report(hv1.HealthCheckResponse_UNKNOWN, err)
+			}
+		} else {
+			servingStatus, checkErr := pollOnce(interrupted, connection, config)
+			progress.report(servingStatus, checkErr)
+			if checkErr == nil && servingStatus.String() == config.waitFor {
+				err = errStatusReached
+			}
+		}
+
+		if err == errStatusReached {
+			fmt.Fprintln(w, config.waitFor)
+			return cli.NewExitError("", 0)
+		}
+		select {
+		case <-interrupted.Done():
+		case <-time.After(config.interval):
+		}
+		if ctx.Err() != nil {
+			message := fmt.Sprintf("timed out waiting for %s after %s, %s", config.waitFor, config.waitTimeout,
+				progress.last)
+			return cli.NewExitError(message, failureExitCode(config, status.FromContextError(ctx.Err()).Err()))
+		}
+		if interrupted.Err() != nil {
+			return cli.NewExitError(fmt.Sprintf("interrupted while waiting for %s", config.waitFor),
+				failureExitCode(config, interrupted.Err()))
+		}
+	}
+}
+
+// pollOnce checks health once, unknown service is reported as SERVICE_UNKNOWN status with --not-found-as-unknown
+func pollOnce(ctx context.Context, connection *grpc.ClientConn, config *appConfig) (
+	hv1.HealthCheckResponse_ServingStatus, error) {
+	ctx, cancel := context.WithTimeout(ctx, config.timeout)
+	defer cancel()
+	return config.statusPolicy.apply(check(ctx, connection, config.serviceName))
+}
+
+// waitProgress reports status or error to stderr whenever it changes
+type waitProgress struct {
+	config *appConfig
+	last   string
+}
+
+func (progress *waitProgress) report(servingStatus hv1.HealthCheckResponse_ServingStatus, err error) {
+	current := fmt.Sprintf("status is %s", servingStatus.String())
+	if err != nil {
+		current = toHumanReadable(err, progress.config.serviceName).Error()
+	}
+	if current != progress.last {
+		fmt.Fprintf(os.Stderr, "%s waiting for %s, %s\n", timestamp(), progress.config.waitFor, current)
+		progress.last = current
+	}
+}
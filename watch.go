@@ -0,0 +1,172 @@
+// PUBLIC DOMAIN NOTICE
+// National Center for Biotechnology Information
+//
+// This software/database is a "United States Government Work" under the
+// terms of the United States Copyright Act.  It was written as part of
+// the author's official duties as a United States Government employee and
+// thus cannot be copyrighted.  This software/database is freely available
+// to the public for use. The National Library of Medicine and the U.S.
+// Government have not placed any restriction on its use or reproduction.
+//
+// Although all reasonable efforts have been taken to ensure the accuracy
+// and reliability of the software and data, the NLM and the U.S.
+// Government do not and cannot warrant the performance or results that
+// may be obtained by using this software or data. The NLM and the U.S.
+// Government disclaim all warranties, express or implied, including
+// warranties of performance, merchantability or fitness for any particular
+// purpose.
+//
+// Please cite the author in any work or product based on this material.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/urfave/cli"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	hv1 "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+	"io"
+	"os"
+	"time"
+)
+
+func watchCommand() cli.Command {
+	flags := &appFlags{}
+	return cli.Command{
+		Name:         "watch",
+		Usage:        "print every health status change reported by the server, reconnect if the stream breaks",
+		ArgsUsage:    "server_address [service_name]",
+		HideHelp:     true,
+		OnUsageError: onCommandUsageError,
+		Flags: append(append(connectionFlags(flags),
+			cli.BoolFlag{
+				Name:        "stop-on-failure, s",
+				Usage:       "Exit on the first status other than SERVING",
+				Destination: &flags.stopOnFailure,
+			},
+			cli.DurationFlag{
+				Name:        "reconnect-interval",
+				Usage:       "Delay before reopening a broken stream",
+				Destination: &flags.reconnectInterval,
+				Value:       1 * time.Second,
+			},
+			legacyExitCodesFlag(flags),
+			printHeadersFlag(flags),
+		), webhookFlags(flags)...),
+		Action: func(c *cli.Context) error {
+			config, err := createConfig(flags, c.Args())
+			if err != nil {
+				return onCommandUsageError(c, err, false)
+			}
+			config.notifier, err = createNotifier(flags)
+			if err != nil {
+				return onCommandUsageError(c, err, false)
+			}
+			return watchMain(config)
+		},
+	}
+}
+
+func watchMain(config *appConfig) *cli.ExitError {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	cancelOnInterrupt(cancel)
+
+	connection, err := connect(ctx, config)
+	if err != nil {
+		return cli.NewExitError(fmt.Sprintf("can't connect to application: %s", err.Error()), ExitCodeUnexpected)
+	}
+	defer connection.Close()
+	defer config.notifier.close()
+
+	// webhooks are notified on every status change, UNKNOWN is reported when the stream breaks
+	last := hv1.HealthCheckResponse_UNKNOWN.String()
+	onChange := func(current string, err error) {
+		if current == last {
+			return
+		}
+		e := healthEvent{
+			Target:    config.serverAddress,
+			Service:   config.serviceName,
+			Previous:  last,
+			Current:   current,
+			Timestamp: time.Now(),
+		}
+		if err != nil {
+			e.Error = toHumanReadable(err, config.serviceName).Error()
+		}
+		config.notifier.notify(e)
+		last = current
+	}
+
+	onUpdate := func(servingStatus hv1.HealthCheckResponse_ServingStatus) error {
+		fmt.Fprintf(os.Stdout, "%s %s\n", timestamp(), servingStatus.String())
+		onChange(servingStatus.String(), nil)
+		if config.stopOnFailure && servingStatus != hv1.HealthCheckResponse_SERVING {
+			return cli.NewExitError("health-check failed", ExitCodeHealthCheckNegative)
+		}
+		return nil
+	}
+
+	for {
+		err = watch(ctx, connection, config.serviceName, config.timeout, onUpdate)
+		if exitErr, isExitErr := err.(*cli.ExitError); isExitErr {
+			return exitErr
+		}
+		if ctx.Err() != nil {
+			// interrupted by user
+			return cli.NewExitError("", 0)
+		}
+		if status.Code(err) == codes.Unimplemented {
+			return cli.NewExitError("rpc error: server doesn't implement Health.Watch", failureExitCode(config, err))
+		}
+
+		onChange(hv1.HealthCheckResponse_UNKNOWN.String(), err)
+		fmt.Fprintf(os.Stderr, "%s stream broken: %s, reconnecting in %s\n",
+			timestamp(), toHumanReadable(err, config.serviceName), config.reconnectInterval)
+		select {
+		case <-ctx.Done():
+			return cli.NewExitError("", 0)
+		case <-time.After(config.reconnectInterval):
+		}
+	}
+}
+
+// watch opens Health.Watch stream and passes every received status to onUpdate until the stream breaks or onUpdate
+// returns an error. Timeout limits the wait for the first status only, once received the stream is kept open.
+func watch(ctx context.Context, connection *grpc.ClientConn, service string, timeout time.Duration,
+	onUpdate func(hv1.HealthCheckResponse_ServingStatus) error) error {
+	streamCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	timer := time.AfterFunc(timeout, cancel)
+	defer timer.Stop()
+
+	client := hv1.NewHealthClient(connection)
+	stream, err := client.Watch(streamCtx, &hv1.HealthCheckRequest{
+		Service: service,
+	})
+	for err == nil {
+		var response *hv1.HealthCheckResponse
+		response, err = stream.Recv()
+		if err != nil {
+			break
+		}
+		timer.Stop()
+		err = onUpdate(response.Status)
+	}
+
+	if err == io.EOF {
+		return fmt.Errorf("stream closed by server")
+	}
+	if ctx.Err() == nil && streamCtx.Err() != nil {
+		return status.Errorf(codes.DeadlineExceeded, "no health status received in %s", timeout)
+	}
+	return err
+}
+
+func timestamp() string {
+	return time.Now().Format(time.RFC3339)
+}
@@ -0,0 +1,253 @@
+// PUBLIC DOMAIN NOTICE
+// National Center for Biotechnology Information
+//
+// This software/database is a "United States Government Work" under the
+// terms of the United States Copyright Act.  It was written as part of
+// the author's official duties as a United States Government employee and
+// thus cannot be copyrighted.  This software/database is freely available
+// to the public for use. The National Library of Medicine and the U.S.
+// Government have not placed any restriction on its use or reproduction.
+//
+// Although all reasonable efforts have been taken to ensure the accuracy
+// and reliability of the software and data, the NLM and the U.S.
+// Government do not and cannot warrant the performance or results that
+// may be obtained by using this software or data. The NLM and the U.S.
+// Government disclaim all warranties, express or implied, including
+// warranties of performance, merchantability or fitness for any particular
+// purpose.
+//
+// Please cite the author in any work or product based on this material.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/urfave/cli"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// webhookMaxBackoff limits delay between webhook delivery attempts
+const webhookMaxBackoff = 30 * time.Second
+
+// healthEvent is health state change posted to webhooks
+type healthEvent struct {
+	Target  string `json:"target"`
+	Service string `json:"service"`
+	// Previous and Current are health statuses before and after the change, UNKNOWN if the check failed with an error
+	Previous string `json:"previous"`
+	Current  string `json:"current"`
+	// PreviousState and State are target states (unknown, healthy, unhealthy) before and after the change, set by
+	// daemon only
+	PreviousState string    `json:"previous_state,omitempty"`
+	State         string    `json:"state,omitempty"`
+	Error         string    `json:"error,omitempty"`
+	Timestamp     time.Time `json:"timestamp"`
+}
+
+// deadLetter is an event which couldn't be delivered
+type deadLetter struct {
+	URL   string      `json:"url"`
+	Error string      `json:"error"`
+	Event healthEvent `json:"event"`
+}
+
+// webhookFlags are flags of commands reporting health changes
+func webhookFlags(flags *appFlags) []cli.Flag {
+	return []cli.Flag{
+		cli.StringSliceFlag{
+			Name:  "webhook",
+			Usage: "POST JSON event to specified URL on every health change (repeatable)",
+			Value: &flags.webhooks,
+		},
+		cli.StringFlag{
+			Name: "webhook-template",
+			Usage: "Go template of webhook request body, e.g. '{\"text\": {{json .Target}}}', " +
+				"the event is posted as is by default",
+			Destination: &flags.webhookTemplate,
+		},
+		cli.IntFlag{
+			Name:        "webhook-retries",
+			Usage:       "Number of retries if webhook fails with network error or HTTP 429 or 5xx status",
+			Destination: &flags.webhookRetries,
+			Value:       3,
+		},
+		cli.DurationFlag{
+			Name:        "webhook-retry-backoff",
+			Usage:       "Delay before the first webhook retry, doubled on every next one",
+			Destination: &flags.webhookBackoff,
+			Value:       1 * time.Second,
+		},
+		cli.DurationFlag{
+			Name:        "webhook-timeout",
+			Usage:       "Webhook request timeout",
+			Destination: &flags.webhookTimeout,
+			Value:       5 * time.Second,
+		},
+		cli.StringFlag{
+			Name:        "webhook-dead-letter",
+			Usage:       "Append events which couldn't be delivered to specified file as JSON lines, print them to stderr if not set",
+			Destination: &flags.webhookDeadLetter,
+		},
+	}
+}
+
+// notifier posts events to webhooks in order of arrival without blocking the caller
+type notifier struct {
+	urls       []string
+	template   *template.Template
+	retry      retryPolicy
+	client     *http.Client
+	deadLetter io.Writer
+	queue      chan healthEvent
+	done       chan struct{}
+}
+
+func createNotifier(flags *appFlags) (*notifier, error) {
+	if flags.webhookRetries < 0 {
+		return nil, fmt.Errorf("--webhook-retries can't be negative, got %d", flags.webhookRetries)
+	}
+	if len(flags.webhooks) == 0 && (len(flags.webhookTemplate) > 0 || len(flags.webhookDeadLetter) > 0) {
+		return nil, fmt.Errorf("--webhook-template and --webhook-dead-letter require --webhook")
+	}
+	for _, url := range flags.webhooks {
+		if !strings.HasPrefix(url, "http://") && !strings.HasPrefix(url, "https://") {
+			return nil, fmt.Errorf("webhook URL must start with http:// or https://, got %s", url)
+		}
+	}
+
+	n := &notifier{
+		urls: flags.webhooks,
+		retry: retryPolicy{
+			retries:    flags.webhookRetries,
+			backoff:    flags.webhookBackoff,
+			maxBackoff: webhookMaxBackoff,
+		},
+		client:     &http.Client{Timeout: flags.webhookTimeout},
+		deadLetter: os.Stderr,
+	}
+	if n.retry.maxBackoff < n.retry.backoff {
+		n.retry.maxBackoff = n.retry.backoff
+	}
+	if len(flags.webhookTemplate) > 0 {
+		var err error
+		n.template, err = template.New("webhook").Funcs(template.FuncMap{"json": toJSON}).Parse(flags.webhookTemplate)
+		if err != nil {
+			return nil, fmt.Errorf("can't parse --webhook-template: %s", err.Error())
+		}
+	}
+	if len(flags.webhookDeadLetter) > 0 {
+		file, err := os.OpenFile(flags.webhookDeadLetter, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+		if err != nil {
+			return nil, fmt.Errorf("can't open --webhook-dead-letter: %s", err.Error())
+		}
+		n.deadLetter = file
+	}
+	n.start()
+	return n, nil
+}
+
+func (n *notifier) start() {
+	n.queue = make(chan healthEvent, 100)
+	n.done = make(chan struct{})
+	go func() {
+		defer close(n.done)
+		for e := range n.queue {
+			for _, url := range n.urls {
+				n.deliver(url, e)
+			}
+		}
+	}()
+}
+
+// notify queues the event for delivery. The event goes to dead-letter log if the queue is full
+func (n *notifier) notify(e healthEvent) {
+	if len(n.urls) == 0 {
+		return
+	}
+	select {
+	case n.queue <- e:
+	default:
+		for _, url := range n.urls {
+			n.bury(url, e, fmt.Errorf("too many events pending delivery"))
+		}
+	}
+}
+
+// close delivers queued events and releases dead-letter log
+func (n *notifier) close() {
+	close(n.queue)
+	<-n.done
+	if closer, isCloser := n.deadLetter.(io.Closer); isCloser && n.deadLetter != os.Stderr {
+		closer.Close()
+	}
+}
+
+// deliver posts the event to url retrying transient failures, the event goes to dead-letter log if all attempts fail
+func (n *notifier) deliver(url string, e healthEvent) {
+	body, err := n.render(e)
+	if err != nil {
+		n.bury(url, e, err)
+		return
+	}
+	backoff := n.retry.backoff
+	for attempt := 0; ; attempt++ {
+		var isRetryable bool
+		isRetryable, err = n.post(url, body)
+		if err == nil {
+			return
+		}
+		if !isRetryable || attempt == n.retry.retries {
+			break
+		}
+		time.Sleep(jitter(backoff))
+		backoff = n.retry.nextBackoff(backoff)
+	}
+	n.bury(url, e, err)
+}
+
+// render builds request body, the event is encoded as JSON unless template is set
+func (n *notifier) render(e healthEvent) ([]byte, error) {
+	if n.template == nil {
+		return json.Marshal(e)
+	}
+	body := new(bytes.Buffer)
+	err := n.template.Execute(body, e)
+	if err != nil {
+		return nil, fmt.Errorf("can't render webhook template: %s", err.Error())
+	}
+	return body.Bytes(), nil
+}
+
+// post sends request body to url and tells if the failure is transient
+func (n *notifier) post(url string, body []byte) (isRetryable bool, err error) {
+	response, err := n.client.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return true, err
+	}
+	defer response.Body.Close()
+	io.Copy(ioutil.Discard, response.Body)
+	if response.StatusCode >= 200 && response.StatusCode < 300 {
+		return false, nil
+	}
+	isRetryable = response.StatusCode == http.StatusTooManyRequests || response.StatusCode >= 500
+	return isRetryable, fmt.Errorf("webhook responded with %s", response.Status)
+}
+
+// bury writes undelivered event to dead-letter log
+func (n *notifier) bury(url string, e healthEvent, err error) {
+	line, _ := json.Marshal(deadLetter{URL: url, Error: err.Error(), Event: e})
+	fmt.Fprintf(n.deadLetter, "%s\n", line)
+}
+
+func toJSON(value interface{}) (string, error) {
+	encoded, err := json.Marshal(value)
+	return string(encoded), err
+}
@@ -0,0 +1,192 @@
+// PUBLIC DOMAIN NOTICE
+// National Center for Biotechnology Information
+//
+// This software/database is a "United States Government Work" under the
+// terms of the United States Copyright Act.  It was written as part of
+// the author's official duties as a United States Government employee and
+// thus cannot be copyrighted.  This software/database is freely available
+// to the public for use. The National Library of Medicine and the U.S.
+// Government have not placed any restriction on its use or reproduction.
+//
+// Although all reasonable efforts have been taken to ensure the accuracy
+// and reliability of the software and data, the NLM and the U.S.
+// Government do not and cannot warrant the performance or results that
+// may be obtained by using this software or data. The NLM and the U.S.
+// Government disclaim all warranties, express or implied, including
+// warranties of performance, merchantability or fitness for any particular
+// purpose.
+//
+// Please cite the author in any work or product based on this material.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// receiver records webhook requests and responds with given status codes in turn, 200 once they are over
+type receiver struct {
+	bodies   []string
+	statuses []int
+}
+
+func (r *receiver) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	body, _ := ioutil.ReadAll(req.Body)
+	r.bodies = append(r.bodies, string(body))
+	if len(r.statuses) > 0 {
+		w.WriteHeader(r.statuses[0])
+		r.statuses = r.statuses[1:]
+	}
+}
+
+var sampleEvent = healthEvent{
+	Target:    "localhost:1234",
+	Service:   "foo",
+	Previous:  "SERVING",
+	Current:   "NOT_SERVING",
+	Timestamp: time.Date(2018, 1, 30, 12, 0, 0, 0, time.UTC),
+}
+
+func newTestNotifier(t *testing.T, flags *appFlags) (*notifier, *bytes.Buffer) {
+	n, err := createNotifier(flags)
+	if err != nil {
+		t.Fatal(err)
+	}
+	deadLetters := new(bytes.Buffer)
+	n.deadLetter = deadLetters
+	return n, deadLetters
+}
+
+func Test_notifier_postsEvent(t *testing.T) {
+	// given
+	r := &receiver{}
+	server := httptest.NewServer(r)
+	defer server.Close()
+	n, deadLetters := newTestNotifier(t, &appFlags{webhooks: []string{server.URL}})
+
+	// when
+	n.notify(sampleEvent)
+	n.close()
+
+	// then
+	assert.Equal(t, []string{`{"target":"localhost:1234","service":"foo","previous":"SERVING",` +
+		`"current":"NOT_SERVING","timestamp":"2018-01-30T12:00:00Z"}`}, r.bodies)
+	assert.Empty(t, deadLetters.String())
+}
+
+func Test_notifier_template(t *testing.T) {
+	// given
+	r := &receiver{}
+	server := httptest.NewServer(r)
+	defer server.Close()
+	n, _ := newTestNotifier(t, &appFlags{
+		webhooks:        []string{server.URL},
+		webhookTemplate: `{"text": {{printf "%s %s is %s" .Target .Service .Current | json}}}`,
+	})
+
+	// when
+	n.notify(sampleEvent)
+	n.close()
+
+	// then
+	assert.Equal(t, []string{`{"text": "localhost:1234 foo is NOT_SERVING"}`}, r.bodies)
+}
+
+func Test_notifier_retriesTransientFailures(t *testing.T) {
+	// given
+	r := &receiver{statuses: []int{http.StatusServiceUnavailable, http.StatusTooManyRequests}}
+	server := httptest.NewServer(r)
+	defer server.Close()
+	n, deadLetters := newTestNotifier(t, &appFlags{webhooks: []string{server.URL}, webhookRetries: 2,
+		webhookBackoff: time.Millisecond})
+
+	// when
+	n.notify(sampleEvent)
+	n.close()
+
+	// then
+	assert.Len(t, r.bodies, 3)
+	assert.Empty(t, deadLetters.String())
+}
+
+func Test_notifier_deadLetter(t *testing.T) {
+	// given
+	dataset := []struct {
+		statuses []int
+		attempts int
+		message  string
+	}{
+		{[]int{500, 502, 503}, 3, "should give up after retries"},
+		{[]int{400}, 1, "should not retry client errors"},
+	}
+
+	for _, tt := range dataset {
+		r := &receiver{statuses: tt.statuses}
+		server := httptest.NewServer(r)
+		n, deadLetters := newTestNotifier(t, &appFlags{webhooks: []string{server.URL}, webhookRetries: 2,
+			webhookBackoff: time.Millisecond})
+
+		// when
+		n.notify(sampleEvent)
+		n.close()
+		server.Close()
+
+		// then
+		assert.Len(t, r.bodies, tt.attempts, tt.message)
+		letter := deadLetter{}
+		assert.NoError(t, json.Unmarshal(deadLetters.Bytes(), &letter), tt.message)
+		assert.Equal(t, server.URL, letter.URL, tt.message)
+		assert.Contains(t, letter.Error, "webhook responded with", tt.message)
+		assert.Equal(t, sampleEvent, letter.Event, tt.message)
+	}
+}
+
+func Test_createNotifier_invalid(t *testing.T) {
+	// given
+	dataset := []struct {
+		flags   *appFlags
+		message string
+	}{
+		{&appFlags{webhooks: []string{"localhost:8080"}}, "should fail, URL has no scheme"},
+		{&appFlags{webhooks: []string{"http://localhost"}, webhookTemplate: "{{.Foo"}, "should fail, invalid template"},
+		{&appFlags{webhooks: []string{"http://localhost"}, webhookRetries: -1}, "should fail, negative retries"},
+		{&appFlags{webhookTemplate: "{{.Target}}"}, "should fail, template without webhook"},
+	}
+
+	for _, tt := range dataset {
+		// when
+		_, err := createNotifier(tt.flags)
+
+		// then
+		assert.Error(t, err, tt.message)
+	}
+}
+
+func Test_transitionEvent(t *testing.T) {
+	// given
+	config := &appConfig{serverAddress: "localhost:1234", serviceName: "foo"}
+
+	// when
+	e := transitionEvent(config, transition{time: epoch, previous: stateHealthy, current: stateUnhealthy, count: 3,
+		result: refused, previousResult: serving})
+
+	// then
+	assert.Equal(t, healthEvent{
+		Target:        "localhost:1234",
+		Service:       "foo",
+		Previous:      "SERVING",
+		Current:       "UNKNOWN",
+		PreviousState: stateHealthy,
+		State:         stateUnhealthy,
+		Error:         "connection refused: application isn't listening or TLS handshake failed",
+		Timestamp:     epoch,
+	}, e)
+}